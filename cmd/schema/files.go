@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	xml2json "github.com/basgys/goxml2json"
+	"github.com/jbrough/json2Leaf"
+)
+
+// mapped is the leaves of a file, or why it couldn't be mapped.
+type mapped struct {
+	path   string
+	leaves []json2Leaf.Leaf
+	err    error
+}
+
+// mapFile reads a JSON or XML file and maps it to leaves, named after the
+// file. Deterministic ids are seeded from its path in dir, so files of the
+// same name in different directories don't share ids. JSON is streamed,
+// unless ids hash the content, which needs the whole file.
+func mapFile(config json2Leaf.Config, dir, path string) ([]json2Leaf.Leaf, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	mapper := json2Leaf.NewMapper(config)
+	if rel, err := filepath.Rel(dir, path); err == nil {
+		mapper.WithSource(filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))))
+	}
+
+	if strings.ToLower(filepath.Ext(path)) == ".xml" {
+		fileData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading file: %w", err)
+		}
+		unescaped := strings.ReplaceAll(string(fileData), "\\n", "\n")
+		unescaped = strings.ReplaceAll(unescaped, "\\\"", "\"")
+		converted, err := xml2json.Convert(strings.NewReader(unescaped))
+		if err != nil {
+			return nil, fmt.Errorf("converting XML to JSON: %w", err)
+		}
+
+		return mapper.Do(name, converted.Bytes())
+	}
+
+	if config.ContentHashIDs {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading file: %w", err)
+		}
+
+		return mapper.Do(name, b)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	defer f.Close()
+
+	return mapper.DoReader(name, bufio.NewReader(f))
+}
+
+// mapFiles maps files found in dir on workers goroutines, passing each file's
+// leaves through process, and sends them on the returned channel, which is
+// closed after the last. When ordered, files are sent in the order given, and
+// workers only map as far ahead of the file being waited for as there are
+// workers.
+func mapFiles(config json2Leaf.Config, dir string, files []string, workers int, ordered bool, process func([]json2Leaf.Leaf) []json2Leaf.Leaf) <-chan mapped {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type job struct {
+		path string
+		out  chan<- mapped
+	}
+
+	jobs := make(chan job)
+	results := make(chan mapped, workers)
+	// the channel each file's result is sent on, in the order of files
+	slots := make(chan chan mapped, workers)
+
+	go func() {
+		defer close(jobs)
+		defer close(slots)
+
+		for _, path := range files {
+			if !ordered {
+				jobs <- job{path, results}
+				continue
+			}
+
+			slot := make(chan mapped, 1)
+			jobs <- job{path, slot}
+			slots <- slot
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				leaves, err := mapFile(config, dir, j.path)
+				if err == nil {
+					leaves = process(leaves)
+				}
+				j.out <- mapped{j.path, leaves, err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(results)
+
+		if !ordered {
+			wg.Wait()
+			return
+		}
+		for slot := range slots {
+			results <- <-slot
+		}
+	}()
+
+	return results
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jbrough/json2Leaf"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFiles writes each file's content under dir, creating directories.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMapFileSameName(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"2024-10-01/report.json": `{"total": 1}`,
+		"2024-10-02/report.json": `{"total": 1}`,
+	})
+
+	config := json2Leaf.Config{DeterministicIDs: true}
+
+	var ids []string
+	for _, day := range []string{"2024-10-01", "2024-10-02"} {
+		leaves, err := mapFile(config, dir, filepath.Join(dir, day, "report.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.NotEmpty(t, leaves) {
+			assert.Equal(t, "report", leaves[0].Name)
+			ids = append(ids, leaves[0].ID)
+		}
+	}
+
+	if assert.Len(t, ids, 2) {
+		assert.NotEqual(t, ids[0], ids[1])
+	}
+
+	again, err := mapFile(config, dir, filepath.Join(dir, "2024-10-01", "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotEmpty(t, again) {
+		assert.Equal(t, ids[0], again[0].ID)
+	}
+}
+
+func TestMapFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	contents := make(map[string]string)
+	var files []string
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("%02d.json", i)
+		contents[path] = fmt.Sprintf(`{"n": %d}`, i)
+		files = append(files, filepath.Join(dir, path))
+	}
+	writeFiles(t, dir, contents)
+
+	var processed int32
+	process := func(ls []json2Leaf.Leaf) []json2Leaf.Leaf {
+		atomic.AddInt32(&processed, 1)
+		return ls
+	}
+
+	for _, ordered := range []bool{true, false} {
+		atomic.StoreInt32(&processed, 0)
+
+		var got []string
+		for m := range mapFiles(json2Leaf.Config{}, dir, files, 4, ordered, process) {
+			if !assert.NoError(t, m.err) {
+				continue
+			}
+			got = append(got, m.path)
+
+			for _, l := range m.leaves {
+				if l.Name != "_tree" {
+					assert.Equal(t, fmt.Sprintf("%02.0f", l.Value), strings.TrimSuffix(filepath.Base(m.path), ".json"))
+				}
+			}
+		}
+
+		if ordered {
+			assert.Equal(t, files, got)
+		} else {
+			assert.ElementsMatch(t, files, got)
+		}
+		assert.Equal(t, int32(len(files)), atomic.LoadInt32(&processed))
+	}
+}
+
+func TestMapFilesError(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.json": `{"a": 1}`,
+		"b.json": `{"b": `,
+		"c.json": `{"c": 3}`,
+	})
+	files := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json"), filepath.Join(dir, "c.json")}
+
+	var errs []string
+	var n int
+	for m := range mapFiles(json2Leaf.Config{}, dir, files, 2, true, func(ls []json2Leaf.Leaf) []json2Leaf.Leaf { return ls }) {
+		n++
+		if m.err != nil {
+			errs = append(errs, filepath.Base(m.path))
+		}
+	}
+
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"b.json"}, errs)
+}
+
+func TestMapFileContentHashIDs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.json": `{"a": 1}`,
+	})
+
+	config := json2Leaf.Config{DeterministicIDs: true, ContentHashIDs: true}
+
+	a, err := mapFile(config, dir, filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := mapFile(config, dir, filepath.Join(dir, "a.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.NotEmpty(t, a) && assert.NotEmpty(t, again) {
+		assert.Equal(t, a[0].ID, again[0].ID)
+	}
+}
@@ -2,96 +2,161 @@ package main
 
 import (
 	"bufio"
+	"database/sql"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
 
-	xml2json "github.com/basgys/goxml2json"
 	"github.com/jbrough/json2Leaf"
 	"github.com/jbrough/json2Leaf/schema"
+	_ "github.com/mattn/go-sqlite3"
 )
 
+// drivers are the database/sql drivers built in, by dialect name.
+var drivers = map[string]string{
+	"sqlite": "sqlite3",
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: program <input_dir>")
+	dialect := flag.String("dialect", "postgres", "SQL dialect: postgres, sqlite, mysql or duckdb")
+	binaryDir := flag.String("binary", "", "write postgres rows as binary COPY files to this directory")
+	appendMode := flag.Bool("append", false, "merge into the existing nodes table instead of replacing it")
+	keys := flag.Bool("keys", false, "add a primary key and indexes to the nodes table")
+	treeViews := flag.Bool("tree-views", false, "create views and functions for recursive tree queries")
+	treePath := flag.Bool("tree-path", false, "add a tree_path column of ancestor ids, an ltree on postgres")
+	closure := flag.Bool("closure", false, "add a node_closure table of every node's ancestors")
+	dsn := flag.String("db", "", "load straight into this database instead of writing a script (sqlite only)")
+	batchSize := flag.Int("batch", 500, "rows per INSERT when loading with -db")
+	workers := flag.Int("workers", runtime.NumCPU(), "files to read and map at once")
+	ordered := flag.Bool("ordered", true, "write files in the order they're found, instead of as soon as they're mapped")
+	var output string
+	flag.StringVar(&output, "o", "output.sql", "write the script to this file, or stdout for -")
+	flag.StringVar(&output, "output", "output.sql", "same as -o")
+	configPath := flag.String("config", "", "YAML or JSON config file, see json2Leaf.ConfigFile")
+	var include, exclude patterns
+	flag.Var(&include, "include", "only read files matching this glob, by path in input_dir or name (repeatable)")
+	flag.Var(&exclude, "exclude", "skip files and directories matching this glob (repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <input_dir>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	inputDir := os.Args[1]
+	inputDir := flag.Arg(0)
+	// progress goes to stderr when the script goes to stdout
+	var log io.Writer = os.Stdout
+	if output == "-" {
+		log = os.Stderr
+	}
 	config := json2Leaf.NewConfig()
+	var replacer *json2Leaf.Replacer
+	var redactor *json2Leaf.Redactor
+	if *configPath != "" {
+		c, err := json2Leaf.LoadConfigFile(*configPath)
+		if err != nil {
+			fmt.Fprintf(log, "Error reading config:\n%v\n", err)
+			os.Exit(1)
+		}
+		config = c.Config()
+		replacer = c.Replacer()
+		redactor = c.Redactor()
+	}
 	generator := schema.NewGenerator()
-	f, err := os.Create("output.sql")
+	d, err := schema.LookupDialect(*dialect)
 	if err != nil {
-		fmt.Printf("Error creating output file: %v\n", err)
-		os.Exit(1)
-	}
-	defer f.Close()
-	generator.File = f
-	generator.Writer = bufio.NewWriter(f)
-	defer generator.Close()
-	if err := generator.WriteInitScript(); err != nil {
-		fmt.Printf("Error writing schema: %v\n", err)
+		fmt.Fprintln(log, err)
 		os.Exit(1)
 	}
-	files := []string{}
-	err = filepath.Walk(inputDir, func(path string, info os.FileInfo, err error) error {
+	generator.Dialect = d
+	generator.BinaryDir = *binaryDir
+	generator.Append = *appendMode
+	generator.Keys = *keys
+	generator.TreeViews = *treeViews
+	generator.TreePath = *treePath
+	generator.Closure = *closure
+	// rows are only replaced when their ids are the same on every run
+	config.DeterministicIDs = config.DeterministicIDs || *appendMode
+	var loader schema.Loader = generator
+	if *dsn != "" {
+		driver, ok := drivers[d.Name()]
+		if !ok {
+			fmt.Fprintf(log, "No database driver is built in for %s\n", d.Name())
+			os.Exit(1)
+		}
+		db, err := sql.Open(driver, *dsn)
 		if err != nil {
-			return err
+			fmt.Fprintf(log, "Error opening database: %v\n", err)
+			os.Exit(1)
 		}
-		if !info.IsDir() {
-			switch strings.ToLower(filepath.Ext(path)) {
-			case ".xml", ".json":
-				files = append(files, path)
-			}
+		defer db.Close()
+		sqlLoader := schema.NewSQLLoader(db, generator)
+		sqlLoader.BatchSize = *batchSize
+		loader = sqlLoader
+	} else if output == "-" {
+		generator.Writer = bufio.NewWriter(os.Stdout)
+	} else {
+		f, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(log, "Error creating output file: %v\n", err)
+			os.Exit(1)
 		}
-		return nil
-	})
+		defer f.Close()
+		generator.File = f
+		generator.Writer = bufio.NewWriter(f)
+	}
+	if err := loader.Begin(); err != nil {
+		fmt.Fprintf(log, "Error writing schema: %v\n", err)
+		os.Exit(1)
+	}
+	files, err := findFiles(inputDir, include, exclude)
 	if err != nil {
-		fmt.Printf("Error walking directory: %v\n", err)
+		fmt.Fprintf(log, "Error walking directory: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Found %d files\n", len(files))
-	var totalLeaves int
-	for i, path := range files {
-		fmt.Printf("[%d/%d] Processing %s\n", i+1, len(files), filepath.Base(path))
-		ext := strings.ToLower(filepath.Ext(path))
-		var jsonData []byte
-		if ext == ".xml" {
-			fileData, err := ioutil.ReadFile(path)
-			if err != nil {
-				fmt.Println("Error reading file:", err)
-				return
-			}
-			unescaped := strings.ReplaceAll(string(fileData), "\\n", "\n")
-			unescaped = strings.ReplaceAll(unescaped, "\\\"", "\"")
-			converted, err := xml2json.Convert(strings.NewReader(unescaped))
-			if err != nil {
-				fmt.Println("Error converting XML to JSON:", err)
-				return
-			}
-
-			jsonData = converted.Bytes()
-		} else {
-			data, err := ioutil.ReadFile(path)
-			if err != nil {
-				fmt.Printf("Error reading file: %v\n", err)
-				continue
-			}
-			jsonData = data
+	fmt.Fprintf(log, "Found %d files\n", len(files))
+	var totalLeaves, i, failed int
+	// replacements and redactions are made on the workers
+	process := func(ls []json2Leaf.Leaf) []json2Leaf.Leaf {
+		if replacer != nil {
+			ls = replacer.Do(ls)
 		}
-		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-		mapper := json2Leaf.NewMapper(config)
-		leaves, err := mapper.Do(name, jsonData)
-		if err != nil {
-			fmt.Printf("Error processing file: %v\n", err)
+		if redactor != nil {
+			ls = redactor.Do(ls)
+		}
+		return ls
+	}
+	for m := range mapFiles(config, inputDir, files, *workers, *ordered, process) {
+		i++
+		fmt.Fprintf(log, "[%d/%d] Processing %s\n", i, len(files), filepath.Base(m.path))
+		if m.err != nil {
+			fmt.Fprintf(log, "Error processing file: %v\n", m.err)
+			failed++
 			continue
 		}
-		totalLeaves += len(leaves)
-		fmt.Printf("Generated %d leaves (total: %d)\n", len(leaves), totalLeaves)
-		if err := generator.WriteLeaves(leaves); err != nil {
-			fmt.Printf("Error writing leaves: %v\n", err)
+		totalLeaves += len(m.leaves)
+		fmt.Fprintf(log, "Generated %d leaves (total: %d)\n", len(m.leaves), totalLeaves)
+		generator.BeginIngest(m.path)
+		if err := loader.WriteLeaves(m.leaves); err != nil {
+			fmt.Fprintf(log, "Error writing leaves: %v\n", err)
+			failed++
 		}
 	}
-	fmt.Printf("Done! Processed %d files, generated %d leaves\n", len(files), totalLeaves)
+	if err := loader.Close(); err != nil {
+		fmt.Fprintf(log, "Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+	if n := generator.DroppedNULs(); n > 0 {
+		fmt.Fprintf(log, "Warning: dropped %d NUL bytes that COPY can't load\n", n)
+	}
+	if failed > 0 {
+		fmt.Fprintf(log, "Failed! %d of %d files couldn't be processed, generated %d leaves\n", failed, len(files), totalLeaves)
+		os.Exit(1)
+	}
+	fmt.Fprintf(log, "Done! Processed %d files, generated %d leaves\n", len(files), totalLeaves)
 }
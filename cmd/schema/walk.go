@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// patterns is a flag that can be given more than once, holding
+// filepath.Match patterns.
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(s string) error {
+	if _, err := filepath.Match(s, ""); err != nil {
+		return fmt.Errorf("%q: %w", s, err)
+	}
+	*p = append(*p, s)
+
+	return nil
+}
+
+// match reports whether any pattern matches path, relative to the input
+// directory, or its base name, so *.json matches at any depth.
+func (p patterns) match(path string) bool {
+	for _, pattern := range p {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findFiles walks dir for JSON and XML files, keeping those matching an
+// include pattern, if there are any, and skipping files and directories
+// matching an exclude pattern.
+func findFiles(dir string, include, exclude patterns) ([]string, error) {
+	var files []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && exclude.match(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".xml", ".json":
+		default:
+			return nil
+		}
+		if len(include) > 0 && !include.match(rel) {
+			return nil
+		}
+
+		files = append(files, path)
+		return nil
+	})
+
+	return files, err
+}
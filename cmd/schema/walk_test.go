@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternsMatch(t *testing.T) {
+	for _, tc := range []struct {
+		patterns patterns
+		path     string
+		match    bool
+	}{
+		{patterns{"*.json"}, "a.json", true},
+		{patterns{"*.json"}, filepath.Join("x", "y", "a.json"), true},
+		{patterns{"*.json"}, "a.xml", false},
+		{patterns{filepath.Join("x", "*.json")}, filepath.Join("x", "a.json"), true},
+		{patterns{filepath.Join("x", "*.json")}, filepath.Join("y", "x", "a.json"), false},
+		{patterns{"x"}, filepath.Join("y", "x"), true},
+		{patterns{"a.json", "b.json"}, "b.json", true},
+		{nil, "a.json", false},
+	} {
+		assert.Equal(t, tc.match, tc.patterns.match(tc.path), "%v %s", tc.patterns, tc.path)
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.json":                          `{}`,
+		"b.XML":                           `<b/>`,
+		"notes.txt":                       ``,
+		filepath.Join("x", "c.json"):      `{}`,
+		filepath.Join("x", "d.xml"):       `<d/>`,
+		filepath.Join("y", "x", "e.json"): `{}`,
+		filepath.Join("tmp", "f.json"):    `{}`,
+	})
+
+	for _, tc := range []struct {
+		name             string
+		include, exclude patterns
+		files            []string
+	}{
+		{"all", nil, nil, []string{"a.json", "b.XML", "tmp/f.json", "x/c.json", "x/d.xml", "y/x/e.json"}},
+		{"include base name", patterns{"*.json"}, nil, []string{"a.json", "tmp/f.json", "x/c.json", "y/x/e.json"}},
+		{"include relative path", patterns{filepath.Join("x", "*")}, nil, []string{"x/c.json", "x/d.xml"}},
+		{"exclude dir", nil, patterns{"tmp"}, []string{"a.json", "b.XML", "x/c.json", "x/d.xml", "y/x/e.json"}},
+		{"exclude dir at any depth", nil, patterns{"x"}, []string{"a.json", "b.XML", "tmp/f.json"}},
+		{"exclude relative path", nil, patterns{filepath.Join("y", "x")}, []string{"a.json", "b.XML", "tmp/f.json", "x/c.json", "x/d.xml"}},
+		{"include and exclude", patterns{"*.json"}, patterns{"tmp", "a.json"}, []string{"x/c.json", "y/x/e.json"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := findFiles(dir, tc.include, tc.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var rel []string
+			for _, f := range files {
+				r, err := filepath.Rel(dir, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rel = append(rel, filepath.ToSlash(r))
+			}
+			assert.Equal(t, tc.files, rel)
+		})
+	}
+}
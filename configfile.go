@@ -0,0 +1,332 @@
+package json2Leaf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigVersion is the version of the config file format read by
+// ParseConfigFile.
+const ConfigVersion = 1
+
+// ConfigFile is a Config written out with named fields, as read from YAML or
+// JSON by ParseConfigFile:
+//
+//	version: 1
+//	table_names: [customer]
+//	table_substitutions:
+//	  - {from: invoice__lines, to: line}
+//	column_substitutions:
+//	  - {from: amt, to: amount}
+//	overrides:
+//	  - from: {table: invoice, path: total_amount}
+//	    to: {table: invoice__totals, path: amount}
+//	replacements:
+//	  - {from: N/A, to: ""}
+//	redactions:
+//	  - {table: customer, path: name}
+//	  - {pattern: '\d{3}-\d{4}', with: '[phone]'}
+//	deterministic_ids: true
+type ConfigFile struct {
+	Version             int            `yaml:"version"`
+	TableNames          []string       `yaml:"table_names"`
+	TableSubstitutions  []Substitution `yaml:"table_substitutions"`
+	ColumnSubstitutions []Substitution `yaml:"column_substitutions"`
+	Overrides           []Override     `yaml:"overrides"`
+	// Replacements replace whole names, paths and string values, ignoring
+	// case. See Replacer.
+	Replacements []Substitution `yaml:"replacements"`
+	Redactions   []Redaction    `yaml:"redactions"`
+
+	DeterministicIDs bool `yaml:"deterministic_ids"`
+	ContentHashIDs   bool `yaml:"content_hash_ids"`
+	KeepEmpty        bool `yaml:"keep_empty"`
+	UseNumber        bool `yaml:"use_number"`
+}
+
+// Substitution replaces From with To.
+type Substitution struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	line int
+}
+
+// Override moves the column at From to the table and path at To.
+type Override struct {
+	From Column `yaml:"from"`
+	To   Column `yaml:"to"`
+	line int
+}
+
+// Column is a path in the table of a node name.
+type Column struct {
+	Table string `yaml:"table"`
+	Path  string `yaml:"path"`
+}
+
+// Redaction is a RedactionRule, with Pattern as a regular expression. Without
+// a pattern it redacts person names.
+type Redaction struct {
+	Table   string `yaml:"table"`
+	Path    string `yaml:"path"`
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+	line    int
+	re      *regexp.Regexp
+}
+
+func (s *Substitution) UnmarshalYAML(n *yaml.Node) error {
+	type plain Substitution
+	s.line = n.Line
+	return n.Decode((*plain)(s))
+}
+
+func (o *Override) UnmarshalYAML(n *yaml.Node) error {
+	type plain Override
+	o.line = n.Line
+	return n.Decode((*plain)(o))
+}
+
+func (r *Redaction) UnmarshalYAML(n *yaml.Node) error {
+	type plain Redaction
+	r.line = n.Line
+	return n.Decode((*plain)(r))
+}
+
+// LoadConfigFile reads and validates the config file at path.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ParseConfigFile(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+// ParseConfigFile reads a config file from YAML, or JSON, which YAML parsers
+// also read. Every problem found is returned, each with its line number.
+func ParseConfigFile(b []byte) (*ConfigFile, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, lineError{1, "version is required"}
+	}
+	doc := root.Content[0]
+
+	var errs []error
+	knownFields(doc, reflect.TypeOf(ConfigFile{}), &errs)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	f := &ConfigFile{}
+	if err := doc.Decode(f); err != nil {
+		return nil, err
+	}
+	if err := f.validate(doc); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// knownFields reports every mapping key in n that isn't a field of t.
+func knownFields(n *yaml.Node, t reflect.Type, errs *[]error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case n.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); tag != "" && tag != "-" {
+				fields[tag] = t.Field(i).Type
+			}
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			ft, ok := fields[k.Value]
+			if !ok {
+				*errs = append(*errs, lineError{k.Line, fmt.Sprintf("unknown field %q", k.Value)})
+				continue
+			}
+			knownFields(n.Content[i+1], ft, errs)
+		}
+
+	case n.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for _, c := range n.Content {
+			knownFields(c, t.Elem(), errs)
+		}
+	}
+}
+
+// lineError is a problem with the value at a line of a config file.
+type lineError struct {
+	line int
+	msg  string
+}
+
+func (e lineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+func (f *ConfigFile) validate(doc *yaml.Node) error {
+	var errs []lineError
+	fail := func(line int, format string, args ...interface{}) {
+		errs = append(errs, lineError{line, fmt.Sprintf(format, args...)})
+	}
+
+	switch line := valueLine(doc, "version"); {
+	case line == 0:
+		fail(doc.Line, "version is required")
+	case f.Version != ConfigVersion:
+		fail(line, "version %d isn't supported, only %d", f.Version, ConfigVersion)
+	}
+
+	for i, name := range f.TableNames {
+		if name == "" {
+			fail(itemLine(doc, "table_names", i), "table_names can't be empty")
+		}
+	}
+
+	for _, subs := range [][]Substitution{f.TableSubstitutions, f.ColumnSubstitutions, f.Replacements} {
+		for _, s := range subs {
+			if s.From == "" {
+				fail(s.line, "from is required")
+			}
+		}
+	}
+
+	for _, o := range f.Overrides {
+		for _, c := range []struct {
+			name  string
+			value string
+		}{
+			{"from.table", o.From.Table},
+			{"from.path", o.From.Path},
+			{"to.table", o.To.Table},
+			{"to.path", o.To.Path},
+		} {
+			if c.value == "" {
+				fail(o.line, "%s is required", c.name)
+			}
+		}
+	}
+
+	for i := range f.Redactions {
+		r := &f.Redactions[i]
+		if r.Pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			fail(r.line, "bad pattern: %v", err)
+		}
+		r.re = re
+	}
+
+	if f.ContentHashIDs && !f.DeterministicIDs {
+		fail(valueLine(doc, "content_hash_ids"), "content_hash_ids needs deterministic_ids")
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].line < errs[j].line })
+
+	var r []error
+	for _, err := range errs {
+		r = append(r, err)
+	}
+
+	return errors.Join(r...)
+}
+
+// valueLine is the line of key's value in the mapping n, or 0 if it isn't
+// there.
+func valueLine(n *yaml.Node, key string) int {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1].Line
+		}
+	}
+
+	return 0
+}
+
+// itemLine is the line of item i of the sequence at key in the mapping n.
+func itemLine(n *yaml.Node, key string, i int) int {
+	for j := 0; j+1 < len(n.Content); j += 2 {
+		if v := n.Content[j+1]; n.Content[j].Value == key && i < len(v.Content) {
+			return v.Content[i].Line
+		}
+	}
+
+	return 0
+}
+
+// Config converts the file to a Config.
+func (f *ConfigFile) Config() Config {
+	c := Config{
+		TableNames:       f.TableNames,
+		DeterministicIDs: f.DeterministicIDs,
+		ContentHashIDs:   f.ContentHashIDs,
+		KeepEmpty:        f.KeepEmpty,
+		UseNumber:        f.UseNumber,
+	}
+
+	for _, s := range f.TableSubstitutions {
+		c.TableSubs = append(c.TableSubs, []string{s.From, s.To})
+	}
+	for _, s := range f.ColumnSubstitutions {
+		c.ColumnSubs = append(c.ColumnSubs, []string{s.From, s.To})
+	}
+	for _, o := range f.Overrides {
+		c.ColumnOverrides = append(c.ColumnOverrides, []string{o.From.Table, o.From.Path, o.To.Table, o.To.Path})
+	}
+
+	return c
+}
+
+// Replacer is a Replacer of the file's replacements, or nil if there are
+// none.
+func (f *ConfigFile) Replacer() *Replacer {
+	if len(f.Replacements) == 0 {
+		return nil
+	}
+
+	var data [][]string
+	for _, r := range f.Replacements {
+		data = append(data, []string{r.To, r.From})
+	}
+
+	return NewReplacer(data)
+}
+
+// Redactor is a Redactor of the file's redactions, or nil if there are none.
+func (f *ConfigFile) Redactor() *Redactor {
+	if len(f.Redactions) == 0 {
+		return nil
+	}
+
+	var rules []RedactionRule
+	for _, r := range f.Redactions {
+		rules = append(rules, RedactionRule{Table: r.Table, Path: r.Path, Pattern: r.re, With: r.With})
+	}
+
+	return NewRedactor(rules)
+}
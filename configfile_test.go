@@ -0,0 +1,99 @@
+package json2Leaf_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFile(t *testing.T) {
+	f, err := j.ParseConfigFile([]byte(`
+version: 1
+table_names: [customer]
+table_substitutions:
+  - {from: invoice__lines, to: line}
+column_substitutions:
+  - from: amt
+    to: amount
+overrides:
+  - from: {table: invoice, path: total}
+    to: {table: invoice__totals, path: amount}
+replacements:
+  - {from: n/a, to: none}
+redactions:
+  - {path: note, pattern: '\d{3}-\d{4}', with: '[phone]'}
+  - {table: invoice__customer}
+deterministic_ids: true
+use_number: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, j.Config{
+		TableNames:       []string{"customer"},
+		TableSubs:        [][]string{{"invoice__lines", "line"}},
+		ColumnSubs:       [][]string{{"amt", "amount"}},
+		ColumnOverrides:  [][]string{{"invoice", "total", "invoice__totals", "amount"}},
+		DeterministicIDs: true,
+		UseNumber:        true,
+	}, f.Config())
+
+	ls := []j.Leaf{
+		{Name: "invoice", Path: "note", Value: "call 555-1234"},
+		{Name: "invoice", Path: "status", Value: "N/A"},
+		{Name: "invoice__customer", Path: "name", Value: "Jane Smith"},
+		{Name: "_tree", Path: "name", Value: "Jane Smith"},
+	}
+	ls = f.Redactor().Do(f.Replacer().Do(ls))
+	assert.Equal(t, "call [phone]", ls[0].Value)
+	assert.Equal(t, "none", ls[1].Value)
+	assert.Equal(t, "[PII redacted]", ls[2].Value)
+	assert.Equal(t, "Jane Smith", ls[3].Value)
+}
+
+func TestConfigFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"version": 1, "keep_empty": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := j.LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, j.Config{KeepEmpty: true}, f.Config())
+	assert.Nil(t, f.Replacer())
+	assert.Nil(t, f.Redactor())
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for _, c := range []struct {
+		doc  string
+		errs string
+	}{
+		{"", "line 1: version is required"},
+		{"version: 2\n", "line 1: version 2 isn't supported, only 1"},
+		{"version: 1\ntable_name: [x]\noverrides:\n  - from: {table: a, column: b}\n",
+			"line 2: unknown field \"table_name\"\nline 4: unknown field \"column\""},
+		{"version: 1\nkeep_empty: maybe\n", "line 2: cannot unmarshal !!str `maybe` into bool"},
+		{`version: 1
+overrides:
+  - from: {table: a, path: b}
+    to: {table: c}
+redactions:
+  - pattern: '('
+column_substitutions:
+  - {to: x}
+content_hash_ids: true
+`, "line 3: to.path is required\nline 6: bad pattern: error parsing regexp: missing closing ): `(`\nline 8: from is required\nline 9: content_hash_ids needs deterministic_ids"},
+	} {
+		_, err := j.ParseConfigFile([]byte(c.doc))
+		if assert.Error(t, err, c.doc) {
+			assert.Contains(t, err.Error(), c.errs)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package json2Leaf
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Detector recognises a semantic type, such as "date" or "uuid", in the text
+// of a string value.
+type Detector func(s string) (semanticType string, ok bool)
+
+var (
+	uuidRe    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	integerRe = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)$`)
+	decimalRe = regexp.MustCompile(`^[-+]?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+	emailRe   = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+	timestampLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02 15:04:05.999999999Z0700",
+	}
+)
+
+// DetectUUID recognises UUIDs in their canonical hyphenated form.
+func DetectUUID(s string) (string, bool) {
+	return "uuid", uuidRe.MatchString(s)
+}
+
+// DetectTimestamp recognises timestamps that carry a zone, such as RFC 3339.
+func DetectTimestamp(s string) (string, bool) {
+	for _, layout := range timestampLayouts {
+		if _, err := time.Parse(layout, s); err == nil {
+			return "timestamptz", true
+		}
+	}
+
+	return "timestamptz", false
+}
+
+// DetectDate recognises ISO 8601 calendar dates like 2006-01-02.
+func DetectDate(s string) (string, bool) {
+	_, err := time.Parse("2006-01-02", s)
+	return "date", err == nil
+}
+
+// DetectInteger recognises integers that fit in an int64. Numbers with
+// leading zeros, like zip codes or account numbers, are left as strings.
+func DetectInteger(s string) (string, bool) {
+	if !integerRe.MatchString(s) {
+		return "integer", false
+	}
+
+	_, err := strconv.ParseInt(s, 10, 64)
+	return "integer", err == nil
+}
+
+// DetectDecimal recognises any other number, including integers too large for
+// DetectInteger.
+func DetectDecimal(s string) (string, bool) {
+	return "decimal", decimalRe.MatchString(s)
+}
+
+// DetectBoolean recognises "true" and "false" in any case.
+func DetectBoolean(s string) (string, bool) {
+	switch strings.ToLower(s) {
+	case "true", "false":
+		return "boolean", true
+	}
+
+	return "boolean", false
+}
+
+// DetectEmail recognises strings that look like an email address.
+func DetectEmail(s string) (string, bool) {
+	return "email", emailRe.MatchString(s)
+}
+
+// DefaultDetectors are tried in order, so more specific types come first.
+var DefaultDetectors = []Detector{
+	DetectUUID,
+	DetectTimestamp,
+	DetectDate,
+	DetectInteger,
+	DetectDecimal,
+	DetectBoolean,
+	DetectEmail,
+}
+
+// NewTypeDetector returns a TypeDetector trying ds in order, or the
+// DefaultDetectors when none are given.
+func NewTypeDetector(ds ...Detector) *TypeDetector {
+	if len(ds) == 0 {
+		ds = DefaultDetectors
+	}
+
+	return &TypeDetector{ds}
+}
+
+// TypeDetector sets the SemanticType of string leaves using the first
+// Detector that recognises the value.
+type TypeDetector struct {
+	detectors []Detector
+}
+
+func (t TypeDetector) Do(ls []Leaf) (r []Leaf) {
+	for _, l := range ls {
+		r = append(r, t.detect(l))
+	}
+
+	return
+}
+
+// Sink returns a LeafSink that detects the type of each leaf before passing
+// it on to next.
+func (t TypeDetector) Sink(next LeafSink) LeafSink {
+	return MapSink(t.detect, next)
+}
+
+func (t TypeDetector) detect(l Leaf) Leaf {
+	s, ok := l.Value.(string)
+	if !ok || l.Name == "_tree" {
+		return l
+	}
+
+	for _, d := range t.detectors {
+		if st, ok := d(s); ok {
+			l.SemanticType = st
+			break
+		}
+	}
+
+	return l
+}
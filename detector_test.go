@@ -0,0 +1,74 @@
+package json2Leaf_test
+
+import (
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeDetector(t *testing.T) {
+	b := []byte(`
+	{
+		"created": "2023-10-01T12:30:00+01:00",
+		"created_utc": "2023-10-01 12:30:00.123Z",
+		"local": "2023-10-01T12:30:00",
+		"day": "2023-10-01",
+		"id": "0b5a3f5e-2a7c-4a55-9d1b-6a3e0f0c9b1a",
+		"count": "42",
+		"zip": "01234",
+		"huge": "123456789012345678901234567890",
+		"price": "-12.50",
+		"flag": "TRUE",
+		"email": "someone@example.com",
+		"text": "hello",
+		"number": 42
+	}`)
+
+	ls, err := j.NewMapper(j.NewConfig()).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	types := make(map[string]string)
+	for _, l := range j.NewTypeDetector().Do(ls) {
+		if l.Name == "_tree" {
+			assert.Equal(t, "", l.SemanticType)
+			continue
+		}
+		types[l.Path] = l.SemanticType
+	}
+
+	assert.Equal(t, map[string]string{
+		"created":     "timestamptz",
+		"created_utc": "timestamptz",
+		"local":       "",
+		"day":         "date",
+		"id":          "uuid",
+		"count":       "integer",
+		"zip":         "",
+		"huge":        "decimal",
+		"price":       "decimal",
+		"flag":        "boolean",
+		"email":       "email",
+		"text":        "",
+		"number":      "",
+	}, types)
+
+	var sunk []j.Leaf
+	_, err = j.NewMapper(j.NewConfig()).WithSink(j.NewTypeDetector(j.DetectDate).Sink(j.LeafSinkFunc(func(l j.Leaf) error {
+		sunk = append(sunk, l)
+		return nil
+	}))).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, l := range sunk {
+		if l.Path == "day" {
+			assert.Equal(t, "date", l.SemanticType)
+		} else {
+			assert.Equal(t, "", l.SemanticType)
+		}
+	}
+}
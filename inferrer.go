@@ -0,0 +1,215 @@
+package json2Leaf
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ColumnSchema summarises the values seen for one (table name, path) pair.
+type ColumnSchema struct {
+	Table string `json:"table"`
+	Path  string `json:"path"`
+	// Type unifies the observed types into one that holds every value.
+	Type string `json:"type"`
+	// Types counts values by their SemanticType, or DataType when there is
+	// none.
+	Types map[string]int `json:"types"`
+	Count int            `json:"count"`
+	Nulls int            `json:"nulls"`
+	// NullRatio is Nulls over Count. Nulls are only mapped to leaves with
+	// KeepEmpty, and absent fields are never counted.
+	NullRatio float64 `json:"null_ratio"`
+	// Empty counts the empty arrays and objects that KeepEmpty maps to
+	// markers. They aren't values, so aren't in Count, Types or Nulls.
+	Empty int `json:"empty"`
+	// Cardinality is the number of distinct non-null values.
+	Cardinality int `json:"cardinality"`
+	// MinLength and MaxLength are of the text of non-null values.
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length"`
+}
+
+func NewSchemaInferrer() *SchemaInferrer {
+	return &SchemaInferrer{
+		columns: make(map[string]*column),
+	}
+}
+
+// SchemaInferrer builds ColumnSchemas from the leaves of any number of
+// documents. It is safe for concurrent use.
+type SchemaInferrer struct {
+	mu      sync.Mutex
+	columns map[string]*column
+}
+
+type column struct {
+	ColumnSchema
+	distinct map[uint64]struct{}
+}
+
+func (s *SchemaInferrer) Add(ls ...Leaf) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range ls {
+		s.add(l)
+	}
+}
+
+// WriteLeaf adds a single leaf, so a SchemaInferrer can be a Mapper's sink.
+func (s *SchemaInferrer) WriteLeaf(l Leaf) error {
+	s.Add(l)
+	return nil
+}
+
+func (s *SchemaInferrer) add(l Leaf) {
+	if l.Name == "_tree" {
+		return
+	}
+
+	key := l.Name + "\x00" + l.Path
+	c, ok := s.columns[key]
+	if !ok {
+		c = &column{
+			ColumnSchema: ColumnSchema{
+				Table: l.Name,
+				Path:  l.Path,
+				Types: make(map[string]int),
+			},
+			distinct: make(map[uint64]struct{}),
+		}
+		s.columns[key] = c
+	}
+
+	if l.Value == nil && (l.DataType == "array" || l.DataType == "object") {
+		c.Empty++
+		return
+	}
+
+	c.Count++
+
+	t := l.DataType
+	if l.SemanticType != "" {
+		t = l.SemanticType
+	}
+	c.Types[t]++
+
+	if l.Value == nil {
+		c.Nulls++
+		return
+	}
+
+	text := fmt.Sprintf("%v", l.Value)
+
+	h := fnv.New64a()
+	h.Write([]byte(t + "\x00" + text))
+	c.distinct[h.Sum64()] = struct{}{}
+
+	if n := len([]rune(text)); c.Count-c.Nulls == 1 {
+		c.MinLength, c.MaxLength = n, n
+	} else {
+		if n < c.MinLength {
+			c.MinLength = n
+		}
+		if n > c.MaxLength {
+			c.MaxLength = n
+		}
+	}
+}
+
+// Columns returns the schema of every column seen so far, sorted by table
+// and path.
+func (s *SchemaInferrer) Columns() (r []ColumnSchema) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.columns {
+		cs := c.ColumnSchema
+
+		cs.Types = make(map[string]int)
+		for t, n := range c.Types {
+			cs.Types[t] = n
+		}
+
+		cs.Type = UnifyTypes(cs.Types)
+		cs.Cardinality = len(c.distinct)
+		if cs.Count > 0 {
+			cs.NullRatio = float64(cs.Nulls) / float64(cs.Count)
+		}
+
+		r = append(r, cs)
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Table != r[j].Table {
+			return r[i].Table < r[j].Table
+		}
+		return r[i].Path < r[j].Path
+	})
+
+	return
+}
+
+// WriteJSON writes Columns to w as an indented JSON array.
+func (s *SchemaInferrer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	cs := s.Columns()
+	if cs == nil {
+		cs = []ColumnSchema{}
+	}
+
+	return enc.Encode(cs)
+}
+
+var numericTypes = map[string]bool{
+	"integer": true,
+	"decimal": true,
+	"float64": true,
+}
+
+// UnifyTypes picks a single type able to hold values of all the given types,
+// ignoring nulls. Numbers unify to "decimal" unless they are all integers,
+// booleans to "bool", dates with timestamps to "timestamptz", and anything
+// else that conflicts falls back to "string".
+func UnifyTypes(types map[string]int) string {
+	var ts []string
+	for t := range types {
+		if t != "null" {
+			ts = append(ts, t)
+		}
+	}
+	sort.Strings(ts)
+
+	switch {
+	case len(ts) == 0:
+		return "null"
+	case len(ts) == 1:
+		return ts[0]
+	}
+
+	all := func(ok func(t string) bool) bool {
+		for _, t := range ts {
+			if !ok(t) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case all(func(t string) bool { return numericTypes[t] }):
+		return "decimal"
+	case all(func(t string) bool { return t == "bool" || t == "boolean" }):
+		return "bool"
+	case all(func(t string) bool { return t == "date" || t == "timestamptz" }):
+		return "timestamptz"
+	}
+
+	return "string"
+}
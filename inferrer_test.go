@@ -0,0 +1,81 @@
+package json2Leaf_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaInferrer(t *testing.T) {
+	docs := [][]byte{
+		[]byte(`{"id": 1, "code": "a1", "amount": 1, "when": "2023-01-01", "note": null, "items": [{"sku": "x"}]}`),
+		[]byte(`{"id": 2, "code": "b22", "amount": "1.5", "when": "2023-01-01T10:00:00Z", "note": "hi", "items": [{"sku": "y"}, {"sku": "x"}]}`),
+		[]byte(`{"id": 3, "code": 7, "amount": 2.25, "when": "2023-01-02", "items": []}`),
+	}
+
+	s := j.NewSchemaInferrer()
+	detector := j.NewTypeDetector()
+
+	for _, b := range docs {
+		_, err := j.NewMapper(j.Config{KeepEmpty: true}).WithSink(detector.Sink(s)).Do("doc", b)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	columns := make(map[string]j.ColumnSchema)
+	for _, c := range s.Columns() {
+		columns[c.Table+"|"+c.Path] = c
+	}
+
+	assert.Equal(t, []string{"doc", "doc", "doc", "doc", "doc", "doc", "doc__items"}, func() (r []string) {
+		for _, c := range s.Columns() {
+			r = append(r, c.Table)
+		}
+		return
+	}())
+
+	id := columns["doc|id"]
+	assert.Equal(t, "float64", id.Type)
+	assert.Equal(t, 3, id.Count)
+	assert.Equal(t, 3, id.Cardinality)
+	assert.Equal(t, 1, id.MinLength)
+
+	code := columns["doc|code"]
+	assert.Equal(t, "string", code.Type)
+	assert.Equal(t, map[string]int{"string": 2, "float64": 1}, code.Types)
+	assert.Equal(t, 1, code.MinLength)
+	assert.Equal(t, 3, code.MaxLength)
+
+	assert.Equal(t, "decimal", columns["doc|amount"].Type)
+	assert.Equal(t, "timestamptz", columns["doc|when"].Type)
+
+	note := columns["doc|note"]
+	assert.Equal(t, "string", note.Type)
+	assert.Equal(t, 1, note.Nulls)
+	assert.Equal(t, 0.5, note.NullRatio)
+
+	sku := columns["doc__items|sku"]
+	assert.Equal(t, 3, sku.Count)
+	assert.Equal(t, 2, sku.Cardinality)
+
+	items := columns["doc|items"]
+	assert.Equal(t, 1, items.Empty)
+	assert.Equal(t, 0, items.Count)
+	assert.Equal(t, 0, items.Nulls)
+	assert.Empty(t, items.Types)
+
+	var buf bytes.Buffer
+	if err := s.WriteJSON(&buf); err != nil {
+		t.Error(err)
+	}
+
+	var exported []j.ColumnSchema
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, s.Columns(), exported)
+}
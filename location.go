@@ -0,0 +1,148 @@
+package json2Leaf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Locations identify a value within a document using RFC 9535 normalized
+// paths, e.g. $['foo'][0]['bar'], which unlike the snake cased Leaf.Path keep
+// the original keys and tell array indexes apart from object keys.
+const rootLoc = "$"
+
+func keyLoc(at, key string) string {
+	var b strings.Builder
+
+	b.WriteString(at)
+	b.WriteString("['")
+	for _, r := range key {
+		switch r {
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteString("']")
+
+	return b.String()
+}
+
+func indexLoc(at string, i int) string {
+	return at + "[" + strconv.Itoa(i) + "]"
+}
+
+// segment is one step of a location: an object key, or an array index when
+// key is false.
+type segment struct {
+	key   bool
+	name  string
+	index int
+}
+
+func parseLoc(loc string) (r []segment, err error) {
+	if !strings.HasPrefix(loc, rootLoc) {
+		return nil, fmt.Errorf("location %q doesn't start with %s", loc, rootLoc)
+	}
+
+	s := loc[len(rootLoc):]
+	for s != "" {
+		if strings.HasPrefix(s, "['") {
+			name, n, err := unquoteKey(s[2:])
+			if err != nil {
+				return nil, fmt.Errorf("location %q: %v", loc, err)
+			}
+
+			r = append(r, segment{key: true, name: name})
+			s = s[2+n:]
+
+			continue
+		}
+
+		end := strings.IndexByte(s, ']')
+		if s[0] != '[' || end < 0 {
+			return nil, fmt.Errorf("location %q is malformed", loc)
+		}
+
+		i, err := strconv.Atoi(s[1:end])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("location %q has a bad index %q", loc, s[1:end])
+		}
+
+		r = append(r, segment{index: i})
+		s = s[end+1:]
+	}
+
+	return
+}
+
+// unquoteKey reads a key written by keyLoc up to and including its closing
+// "']", returning the key and the number of bytes consumed.
+func unquoteKey(s string) (string, int, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			if !strings.HasPrefix(s[i:], "']") {
+				return "", 0, fmt.Errorf("unescaped quote in key")
+			}
+			return b.String(), i + 2, nil
+
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("unterminated escape in key")
+			}
+			i++
+
+			switch s[i] {
+			case '\'', '\\', '/':
+				b.WriteByte(s[i])
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+5 > len(s) {
+					return "", 0, fmt.Errorf("short unicode escape in key")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, fmt.Errorf("bad unicode escape in key")
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c in key", s[i])
+			}
+
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated key")
+}
@@ -1,8 +1,15 @@
 package json2Leaf
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -33,6 +40,27 @@ type Config struct {
 	ColumnSubs      [][]string
 	TableNames      []string
 	TableSubs       [][]string
+
+	// DeterministicIDs derives node ids from the document name, or its
+	// Mapper.WithSource, and each node's location in the document instead of
+	// generating random ones, so mapping an unchanged document twice produces
+	// identical leaves.
+	DeterministicIDs bool
+	// ContentHashIDs also mixes a hash of the document's bytes into
+	// deterministic ids, so a changed document gets a fresh set of ids. It
+	// needs the whole document up front and isn't supported by DoReader.
+	ContentHashIDs bool
+
+	// KeepEmpty maps nulls to leaves with DataType "null", and empty objects
+	// and arrays to marker leaves with DataType "object" and "array", all with
+	// a nil Value. By default they produce no leaves, so a null or empty field
+	// can't be told apart from a missing one.
+	KeepEmpty bool
+
+	// UseNumber keeps numbers as their literal json.Number text instead of
+	// converting them to float64. Their DataType is "integer" when they fit in
+	// an int64 and "decimal" otherwise.
+	UseNumber bool
 }
 
 type Leaf struct {
@@ -42,6 +70,19 @@ type Leaf struct {
 	ParentID string
 	Path     string
 	Value    interface{}
+	// Index is the position of the leaf's node within its parent array when
+	// HasIndex is set. A Leaf's zero value isn't an array element.
+	Index    int
+	HasIndex bool
+	// Location is the normalized JSON path of the value in the source
+	// document, e.g. $['foo'][0]['bar']. It is empty for _tree leaves.
+	Location string
+	// SemanticType is what a string value holds, e.g. "date" or "uuid", when
+	// it has been recognised by a TypeDetector.
+	SemanticType string
+	// Lineage is the ids of the node's ancestors, starting from the root. It
+	// is shared by the leaves of a node and must not be modified.
+	Lineage []string
 }
 
 func NewMapper(c Config) *Mapper {
@@ -59,40 +100,171 @@ func NewMapper(c Config) *Mapper {
 	return &Mapper{
 		config:    c,
 		nodes:     make(map[string]interface{}),
+		lineage:   make(map[string][]string),
+		paths:     make(map[string]map[string]bool),
 		overrides: overrides,
 	}
 }
 
 type Mapper struct {
 	config     Config
+	doc        string
+	docHash    string
+	source     string
 	leaves     []Leaf
 	nodes      map[string]interface{}
 	nodesMutex sync.RWMutex
-	overrides  map[string]map[string][]string
+	lineage    map[string][]string
+	// paths of the nodes being mapped, as keys can fold onto the same path
+	paths     map[string]map[string]bool
+	overrides map[string]map[string][]string
+	sink      LeafSink
+}
+
+// WithSink makes the Mapper hand each leaf to s as soon as it is produced
+// instead of collecting them, so Do, DoPath and DoReader return no leaves.
+func (m *Mapper) WithSink(s LeafSink) *Mapper {
+	m.sink = s
+	return m
+}
+
+// WithSource seeds deterministic ids from source instead of the document
+// name, for documents that share a name but aren't the same document, such
+// as files of the same name in different directories.
+func (m *Mapper) WithSource(source string) *Mapper {
+	m.source = source
+	return m
+}
+
+// MapError is returned when a document can't be mapped. Path is the
+// normalized JSON path of the offending value, e.g. $['items'][3]['price'].
+type MapError struct {
+	Name string
+	Path string
+	Err  error
+}
+
+func (e *MapError) Error() string {
+	return fmt.Sprintf("json2Leaf: %s at %s: %v", e.Name, e.Path, e.Err)
+}
+
+func (e *MapError) Unwrap() error {
+	return e.Err
+}
+
+func (m *Mapper) parse(b []byte) (*gabs.Container, error) {
+	if !m.config.UseNumber {
+		return gabs.ParseJSON(b)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	return gabs.ParseJSONDecoder(dec)
 }
 
 func (m *Mapper) DoPath(name, path string, b []byte) (ls []Leaf, err error) {
-	d, err := gabs.ParseJSON(b)
+	d, err := m.parse(b)
 	if err != nil {
-		return
+		return nil, &MapError{Name: name, Path: rootLoc, Err: err}
 	}
 
-	err = m.do(name, "", "", "", d.Path(path))
+	// a missing path has no leaves, rather than the null it reads as
+	if !d.ExistsP(path) {
+		return nil, nil
+	}
+
+	m.begin(name, b)
+
+	at := rootLoc
+	for _, key := range strings.Split(path, ".") {
+		at = keyLoc(at, key)
+	}
+
+	err = m.do(name, "", "", "", at, -1, d.Path(path))
 
 	return m.leaves, err
 }
 
 func (m *Mapper) Do(name string, b []byte) (ls []Leaf, err error) {
-	d, err := gabs.ParseJSON(b)
+	d, err := m.parse(b)
 	if err != nil {
-		return
+		return nil, &MapError{Name: name, Path: rootLoc, Err: err}
 	}
 
-	err = m.do(name, "", "", "", d)
+	m.begin(name, b)
+
+	err = m.do(name, "", "", "", rootLoc, -1, d)
 
 	return m.leaves, err
 }
 
+// DoReader maps the document read from r, walking it token by token rather
+// than parsing it into memory first. Leaves have the same shape as those
+// returned by Do.
+func (m *Mapper) DoReader(name string, r io.Reader) (ls []Leaf, err error) {
+	if m.config.ContentHashIDs {
+		return nil, &MapError{Name: name, Path: rootLoc, Err: errors.New("ContentHashIDs is not supported when streaming")}
+	}
+
+	m.begin(name, nil)
+
+	dec := json.NewDecoder(r)
+	if m.config.UseNumber {
+		dec.UseNumber()
+	}
+
+	if err = m.stream(name, "", "", "", rootLoc, -1, dec); err != nil {
+		return m.leaves, err
+	}
+
+	// Do rejects anything after the document, so concatenated or corrupt
+	// files aren't loaded in part
+	switch _, err := dec.Token(); err {
+	case io.EOF:
+	case nil:
+		return m.leaves, &MapError{Name: name, Path: rootLoc, Err: errors.New("data after top-level value")}
+	default:
+		return m.leaves, &MapError{Name: name, Path: rootLoc, Err: err}
+	}
+
+	return m.leaves, nil
+}
+
+var idNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/jbrough/json2Leaf"))
+
+func (m *Mapper) begin(doc string, b []byte) {
+	m.doc = doc
+	m.docHash = ""
+	m.paths = make(map[string]map[string]bool)
+
+	if m.config.ContentHashIDs && b != nil {
+		sum := sha256.Sum256(b)
+		m.docHash = hex.EncodeToString(sum[:])
+	}
+}
+
+// newID mints the id of a node. kind distinguishes the different reasons a
+// node is started at the same location.
+func (m *Mapper) newID(kind, at string) string {
+	if !m.config.DeterministicIDs {
+		return uuid.New().String()
+	}
+
+	source := m.doc
+	if m.source != "" {
+		source = m.source
+	}
+
+	seed := strings.Join([]string{source, m.docHash, kind, at}, "\x00")
+
+	return uuid.NewSHA1(idNamespace, []byte(seed)).String()
+}
+
+func (m *Mapper) fail(at string, err error) error {
+	return &MapError{Name: m.doc, Path: at, Err: err}
+}
+
 func (m *Mapper) hasNode(node, parent string) (ok bool) {
 	m.nodesMutex.RLock()
 	defer m.nodesMutex.RUnlock()
@@ -108,7 +280,76 @@ func (m *Mapper) addNode(node, parent string) {
 	m.nodes[node+parent] = struct{}{}
 }
 
-func (m *Mapper) add(name, path, node, parent string, d *gabs.Container) {
+// link records the lineage of node as that of parent followed by parent,
+// unless node is a root or already known, and reports whether it did. Only
+// the nodes being mapped are kept, so whoever linked a node unlinks it once
+// it's done.
+func (m *Mapper) link(node, parent string) bool {
+	m.nodesMutex.Lock()
+	defer m.nodesMutex.Unlock()
+
+	if _, ok := m.lineage[node]; ok || parent == "" {
+		return false
+	}
+
+	up := m.lineage[parent]
+	l := make([]string, len(up)+1)
+	copy(l, up)
+	l[len(up)] = parent
+
+	m.lineage[node] = l
+	return true
+}
+
+func (m *Mapper) unlink(node string) {
+	m.nodesMutex.Lock()
+	defer m.nodesMutex.Unlock()
+
+	delete(m.lineage, node)
+	delete(m.paths, node)
+}
+
+func (m *Mapper) lineageOf(node string) []string {
+	m.nodesMutex.RLock()
+	defer m.nodesMutex.RUnlock()
+
+	return m.lineage[node]
+}
+
+// uniquePath is path, numbered if node already has a value at it. Different
+// keys can fold onto the same path, e.g. fooBar and foo_bar, and each value
+// needs its own path for nodes rows to be unique. Keys are mapped in
+// document order by DoReader and sorted by Do, so the same key is numbered
+// every time.
+func (m *Mapper) uniquePath(node, path string) string {
+	m.nodesMutex.Lock()
+	defer m.nodesMutex.Unlock()
+
+	taken, ok := m.paths[node]
+	if !ok {
+		taken = make(map[string]bool)
+		m.paths[node] = taken
+	}
+
+	unique := path
+	for n := 2; taken[unique]; n++ {
+		unique = fmt.Sprintf("%s_%d", path, n)
+	}
+	taken[unique] = true
+
+	return unique
+}
+
+func (m *Mapper) emit(l Leaf) error {
+	if m.sink != nil {
+		return m.sink.WriteLeaf(l)
+	}
+
+	m.leaves = append(m.leaves, l)
+	return nil
+}
+
+func (m *Mapper) add(name, path, node, parent, at string, index int, dataType string, v interface{}) error {
 	replacer := strings.NewReplacer("-", "", "#", "")
 	path = replacer.Replace(path)
 
@@ -131,92 +372,278 @@ func (m *Mapper) add(name, path, node, parent string, d *gabs.Container) {
 			name = override[0]
 			path = override[1]
 			parent = oldNode
-			node = uuid.New().String()
+			node = m.newID("override", at)
+			index = -1
+			if m.link(node, parent) {
+				defer m.unlink(node)
+			}
 		}
 	}
 
-	m.leaves = append(m.leaves, Leaf{
-		DataType: fmt.Sprintf("%T", d.Data()),
+	path = m.uniquePath(node, path)
+	lineage := m.lineageOf(node)
+
+	// index is -1 while mapping nodes that aren't array elements
+	hasIndex := index >= 0
+	if !hasIndex {
+		index = 0
+	}
+
+	err := m.emit(Leaf{
+		DataType: dataType,
 		Name:     name,
 		ID:       node,
 		ParentID: parent,
 		Path:     path,
-		Value:    d.Data(),
+		Value:    v,
+		Index:    index,
+		HasIndex: hasIndex,
+		Location: at,
+		Lineage:  lineage,
 	})
+	if err != nil {
+		return err
+	}
 
 	if !m.hasNode(node, parent) {
-		m.leaves = append(m.leaves, Leaf{
+		err = m.emit(Leaf{
 			DataType: "string",
 			ID:       node,
 			Name:     "_tree",
 			ParentID: parent,
 			Path:     "name",
 			Value:    name,
+			Index:    index,
+			HasIndex: hasIndex,
+			Lineage:  lineage,
 		})
+		if err != nil {
+			return err
+		}
 
 		m.addNode(node, parent)
 	}
 
+	return nil
 }
 
-func (m *Mapper) do(name, path, node, parent string, d *gabs.Container) error {
-	if d.Data() == nil {
-		return nil
-	}
-
+// enter starts a new node when path is one of the configured table names and
+// mints an id for the root node. The caller links the node it returns.
+func (m *Mapper) enter(name, path, node, parent, at string, index int) (string, string, string, string, int) {
 	for _, tn := range m.config.TableNames {
 		if path == tn {
 			prevNode := node
 			name = path
 			path = ""
 			parent = prevNode
-			node = uuid.New().String()
+			node = m.newID("table", at)
+			index = -1
 		}
 	}
 
 	if node == "" {
-		node = uuid.New().String()
+		node = m.newID("node", at)
+	}
+
+	return name, path, node, parent, index
+}
+
+func dataType(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		if _, err := n.Int64(); err == nil {
+			return "integer"
+		}
+		return "decimal"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+// marker maps a null or empty container to a leaf when KeepEmpty is set.
+func (m *Mapper) marker(name, path, node, parent, at string, index int, dataType string) error {
+	if !m.config.KeepEmpty {
+		return nil
+	}
+
+	if path == "" {
+		path = "val"
+	}
+
+	if err := m.add(name, path, node, parent, at, index, dataType, nil); err != nil {
+		return m.fail(at, err)
+	}
+
+	return nil
+}
+
+func arrayName(name, path string) string {
+	if path != "" && name != "" {
+		return fmt.Sprintf("%s__%s", name, path)
+	}
+
+	return name
+}
+
+func childPath(path, key string) string {
+	if path != "" {
+		return fmt.Sprintf("%s__%s", path, key)
+	}
+
+	return key
+}
+
+func (m *Mapper) do(name, path, node, parent, at string, index int, d *gabs.Container) error {
+	if d.Data() == nil && !m.config.KeepEmpty {
+		return nil
+	}
+
+	name, path, node, parent, index = m.enter(name, path, node, parent, at, index)
+	if m.link(node, parent) {
+		defer m.unlink(node)
 	}
 
 	switch d.Data().(type) {
-	case string, float64, bool:
+	case nil:
+		return m.marker(name, path, node, parent, at, index, "null")
+
+	case string, float64, bool, json.Number:
 		if path == "" {
 			path = "val"
 		}
-		m.add(name, path, node, parent, d)
+		if err := m.add(name, path, node, parent, at, index, dataType(d.Data()), d.Data()); err != nil {
+			return m.fail(at, err)
+		}
 
 		return nil
 
 	case []interface{}:
 		c, err := d.Children()
 		if err != nil {
-			return err
+			return m.fail(at, err)
 		}
 
-		if path != "" && name != "" {
-			name = fmt.Sprintf("%s__%s", name, path)
+		if len(c) == 0 {
+			return m.marker(name, path, node, parent, at, index, "array")
 		}
 
-		for _, child := range c {
-			m.do(name, "", uuid.New().String(), node, child)
+		name = arrayName(name, path)
+
+		for i, child := range c {
+			childAt := indexLoc(at, i)
+			if err := m.do(name, "", m.newID("node", childAt), node, childAt, i, child); err != nil {
+				return err
+			}
 		}
 
 	case map[string]interface{}:
 		cm, err := d.ChildrenMap()
 		if err != nil {
-			return err
+			return m.fail(at, err)
+		}
+
+		if len(cm) == 0 {
+			return m.marker(name, path, node, parent, at, index, "object")
+		}
+
+		// sorted, so a document is mapped the same way every time
+		keys := make([]string, 0, len(cm))
+		for key := range cm {
+			keys = append(keys, key)
 		}
+		sort.Strings(keys)
 
-		for key, child := range cm {
-			nextPath := key
-			if path != "" {
-				nextPath = fmt.Sprintf("%s__%s", path, key)
+		for _, key := range keys {
+			if err := m.do(name, childPath(path, key), node, parent, keyLoc(at, key), index, cm[key]); err != nil {
+				return err
 			}
-			m.do(name, nextPath, node, parent, child)
 		}
 
 	default:
-		panic("default case. We should not get here.")
+		return m.fail(at, fmt.Errorf("unsupported value type %T", d.Data()))
 	}
 	return nil
 }
+
+// stream is the token based equivalent of do. It consumes exactly one value
+// from dec.
+func (m *Mapper) stream(name, path, node, parent, at string, index int, dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return m.fail(at, err)
+	}
+
+	if t == nil && !m.config.KeepEmpty {
+		return nil
+	}
+
+	name, path, node, parent, index = m.enter(name, path, node, parent, at, index)
+	if m.link(node, parent) {
+		defer m.unlink(node)
+	}
+
+	switch v := t.(type) {
+	case nil:
+		return m.marker(name, path, node, parent, at, index, "null")
+
+	case string, float64, bool, json.Number:
+		if path == "" {
+			path = "val"
+		}
+		if err := m.add(name, path, node, parent, at, index, dataType(v), v); err != nil {
+			return m.fail(at, err)
+		}
+
+		return nil
+
+	case json.Delim:
+		switch v {
+		case '[':
+			if !dec.More() {
+				if err := m.marker(name, path, node, parent, at, index, "array"); err != nil {
+					return err
+				}
+			}
+
+			name = arrayName(name, path)
+
+			for i := 0; dec.More(); i++ {
+				childAt := indexLoc(at, i)
+				if err := m.stream(name, "", m.newID("node", childAt), node, childAt, i, dec); err != nil {
+					return err
+				}
+			}
+
+		case '{':
+			if !dec.More() {
+				if err := m.marker(name, path, node, parent, at, index, "object"); err != nil {
+					return err
+				}
+			}
+
+			for dec.More() {
+				t, err := dec.Token()
+				if err != nil {
+					return m.fail(at, err)
+				}
+
+				key := t.(string)
+				if err := m.stream(name, childPath(path, key), node, parent, keyLoc(at, key), index, dec); err != nil {
+					return err
+				}
+			}
+
+		default:
+			return m.fail(at, fmt.Errorf("unexpected delimiter %v", v))
+		}
+
+		// closing delimiter
+		if _, err = dec.Token(); err != nil {
+			return m.fail(at, err)
+		}
+
+		return nil
+
+	default:
+		return m.fail(at, fmt.Errorf("unsupported value type %T", t))
+	}
+}
@@ -1,8 +1,11 @@
 package json2Leaf_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
 	"testing"
 
 	j "github.com/jbrough/json2Leaf"
@@ -41,11 +44,6 @@ func TestDoPathWithTableNameConfig(t *testing.T) {
 		t.Error(err)
 	}
 
-	sc := lander.NewSchema()
-	for _, l := range ls {
-		sc.Add(l)
-	}
-
 	var names []string
 	var paths []string
 
@@ -84,11 +82,6 @@ func TestDoPathWithTableNameConfig(t *testing.T) {
 		t.Error(err)
 	}
 
-	sc = lander.NewSchema()
-	for _, l := range ls {
-		sc.Add(l)
-	}
-
 	names = []string{}
 	paths = []string{}
 
@@ -235,32 +228,9 @@ func TestBug(t *testing.T) {
 
 	c := j.NewConfig()
 
-	ls, err := j.NewMapper(c).Do("test1", test1)
-	if err != nil {
-		t.Error(err)
-	}
-
-	// TODO shouldn't really test this via another package, but don't have time
-	// to write detailed expectations. The functionality is already tested in
-	// this file and better to have this now as a regression test than to not.
-	sc := lander.NewSchema()
-	for _, l := range ls {
-		if l.Name == "_tree" {
-			continue
-		}
-
-		sc.Add(l)
-	}
-
-	csv, err := sc.CSV()
-	if err != nil {
+	if _, err := j.NewMapper(c).Do("test1", test1); err != nil {
 		t.Error(err)
 	}
-
-	assert.Equal(t,
-		"table,column,bool,int,real,text\ntest1__foo__bar2__arr__arr2,val,false,true,false,false\ntest1,flat,false,false,false,true\ntest1__foo,bar2__baz2,false,false,false,true\ntest1__foo,bar__baz,false,false,false,true\ntest1__foo__bar2__arrX,x2A,false,true,false,false\ntest1__foo__bar2__arrX,x1,false,true,false,false\ntest1__foo__bar2__arrX,x2,false,true,false,false\ntest1__foo__bar2__arr__arr3,val,false,true,false,false\n",
-		csv,
-	)
 }
 
 func TestColumnOverrideConfig(t *testing.T) {
@@ -319,3 +289,439 @@ func TestColumnOverrideConfig(t *testing.T) {
 	}
 
 }
+
+func TestDoReader(t *testing.T) {
+	b := []byte(`
+	{
+		"foo": "foo_v",
+		"bar": {
+			"baz": "baz_v",
+			"nil": null
+		},
+		"baz": [
+			{
+				"foo1": 1.3,
+				"ObjectA": {"SubObject": {"x": [1, [2, 3]]}}
+			},
+			{
+				"foo2": 1
+			}
+		],
+		"qux": [
+			"a", 1, false
+		]
+	}`)
+
+	c := j.Config{TableNames: []string{"ObjectA__SubObject"}}
+
+	expected, err := j.NewMapper(c).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	actual, err := j.NewMapper(c).DoReader("doc", bytes.NewReader(b))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// ids are random, so compare leaves by their content and the name of the
+	// node they hang off.
+	shape := func(ls []j.Leaf) (r []string) {
+		names := make(map[string]string)
+		for _, l := range ls {
+			if l.Name == "_tree" {
+				names[l.ID] = l.Value.(string)
+			}
+		}
+
+		for _, l := range ls {
+			r = append(r, fmt.Sprintf("%s|%s|%s|%v|%s",
+				l.Name, l.Path, l.DataType, l.Value, names[l.ParentID]))
+		}
+		sort.Strings(r)
+
+		return
+	}
+
+	assert.Equal(t, shape(expected), shape(actual))
+
+	_, err = j.NewMapper(c).DoReader("doc", strings.NewReader(`{"foo": [1, 2`))
+	assert.Error(t, err)
+
+	// like Do, only one document is read
+	for _, doc := range []string{`{"a":1} {"b": 2} garbage`, `{"a":1} garbage`, "{\"a\":1}\n]"} {
+		_, err = j.NewMapper(c).DoReader("doc", strings.NewReader(doc))
+		var mapErr *j.MapError
+		assert.ErrorAs(t, err, &mapErr, doc)
+	}
+	_, err = j.NewMapper(c).DoReader("doc", strings.NewReader("{\"a\":1}\n\n"))
+	assert.NoError(t, err)
+}
+
+func TestWithSink(t *testing.T) {
+	b := []byte(`{"foo": "foo_v", "bar": [1, 2, 3], "baz": {"qux": true}}`)
+
+	var sunk []j.Leaf
+	ls, err := j.NewMapper(j.NewConfig()).WithSink(j.LeafSinkFunc(func(l j.Leaf) error {
+		sunk = append(sunk, l)
+		return nil
+	})).DoReader("doc", bytes.NewReader(b))
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Empty(t, ls)
+	assert.Equal(t, 9, len(sunk))
+
+	var mapped []j.Leaf
+	_, err = j.NewMapper(j.NewConfig()).WithSink(j.MapSink(func(l j.Leaf) j.Leaf {
+		l.Name = strings.ToUpper(l.Name)
+		return l
+	}, j.LeafSinkFunc(func(l j.Leaf) error {
+		mapped = append(mapped, l)
+		return nil
+	}))).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Equal(t, 9, len(mapped))
+	for _, l := range mapped {
+		assert.Equal(t, strings.ToUpper(l.Name), l.Name)
+	}
+
+	ch := make(chan j.Leaf)
+	done := make(chan int)
+	go func() {
+		var n int
+		for range ch {
+			n++
+		}
+		done <- n
+	}()
+
+	_, err = j.NewMapper(j.NewConfig()).WithSink(j.ChanSink(ch)).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+	close(ch)
+
+	assert.Equal(t, 9, <-done)
+
+	stop := errors.New("stop")
+	var n int
+	_, err = j.NewMapper(j.NewConfig()).WithSink(j.LeafSinkFunc(func(l j.Leaf) error {
+		if n++; n == 3 {
+			return stop
+		}
+		return nil
+	})).Do("doc", b)
+
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 3, n)
+}
+
+func TestDeterministicIDs(t *testing.T) {
+	b := []byte(`{"foo": "foo_v", "bar": [{"baz": 1}, {"baz": 2}], "ObjectA": {"x": true}}`)
+
+	c := j.Config{
+		DeterministicIDs: true,
+		TableNames:       []string{"ObjectA"},
+		ColumnOverrides:  [][]string{{"doc", "foo", "a", "b"}},
+	}
+
+	rows := func(ls []j.Leaf) (r []string) {
+		for _, l := range ls {
+			r = append(r, fmt.Sprintf("%s|%s|%s|%s|%v", l.ID, l.ParentID, l.Name, l.Path, l.Value))
+		}
+		sort.Strings(r)
+
+		return
+	}
+
+	first, err := j.NewMapper(c).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	second, err := j.NewMapper(c).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+
+	streamed, err := j.NewMapper(c).DoReader("doc", bytes.NewReader(b))
+	if err != nil {
+		t.Error(err)
+	}
+
+	assert.Equal(t, rows(first), rows(second))
+	assert.Equal(t, rows(first), rows(streamed))
+
+	ids := make(map[string]bool)
+	for _, l := range first {
+		ids[l.ID] = true
+	}
+	assert.Equal(t, 4, len(ids))
+
+	other, err := j.NewMapper(c).Do("other", b)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.NotEqual(t, first[0].ID, other[0].ID)
+
+	sourced, err := j.NewMapper(c).WithSource("2024-10-01/doc").Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+	for _, l := range sourced {
+		assert.False(t, ids[l.ID])
+	}
+	assert.Equal(t, len(first), len(sourced))
+
+	c.ContentHashIDs = true
+
+	hashed, err := j.NewMapper(c).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.NotEqual(t, rows(first), rows(hashed))
+
+	_, err = j.NewMapper(c).DoReader("doc", bytes.NewReader(b))
+	assert.Error(t, err)
+}
+
+func TestFoldedKeys(t *testing.T) {
+	b := []byte(`{"fooBar": 1, "foo_bar": 2, "items": [{"aB": 3, "a_b": 4}]}`)
+
+	for _, do := range []func() ([]j.Leaf, error){
+		func() ([]j.Leaf, error) { return j.NewMapper(j.NewConfig()).Do("doc", b) },
+		func() ([]j.Leaf, error) { return j.NewMapper(j.NewConfig()).DoReader("doc", bytes.NewReader(b)) },
+	} {
+		ls, err := do()
+		if err != nil {
+			t.Error(err)
+		}
+
+		var rows []string
+		for _, l := range ls {
+			if l.Name != "_tree" {
+				rows = append(rows, fmt.Sprintf("%s|%s|%v", l.Name, l.Path, l.Value))
+			}
+		}
+		sort.Strings(rows)
+
+		assert.Equal(t, []string{
+			"doc__items|a_b_2|4",
+			"doc__items|a_b|3",
+			"doc|foo_bar_2|2",
+			"doc|foo_bar|1",
+		}, rows)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	_, err := j.NewMapper(j.NewConfig()).DoReader("doc", strings.NewReader(`{"foo": [1, {"bar": tru}]}`))
+
+	var me *j.MapError
+	if assert.ErrorAs(t, err, &me) {
+		assert.Equal(t, "doc", me.Name)
+		assert.Equal(t, "$['foo'][1]['bar']", me.Path)
+	}
+
+	stop := errors.New("stop")
+	_, err = j.NewMapper(j.NewConfig()).WithSink(j.LeafSinkFunc(func(l j.Leaf) error {
+		if l.Value == "x" {
+			return stop
+		}
+		return nil
+	})).Do("doc", []byte(`{"foo": [1, 2], "baz": {"it's": "x"}}`))
+
+	assert.ErrorIs(t, err, stop)
+	if assert.ErrorAs(t, err, &me) {
+		assert.Equal(t, `$['baz']['it\'s']`, me.Path)
+	}
+
+	_, err = j.NewMapper(j.NewConfig()).Do("doc", []byte(`{"foo": `))
+	assert.ErrorAs(t, err, &me)
+}
+
+func TestArrayIndex(t *testing.T) {
+	b := []byte(`{"foo": "foo_v", "bar": [{"baz": "a", "qux": {"x": "b"}}, {"baz": "c"}], "qux": ["d", "e", ["f"]]}`)
+
+	for _, do := range []func() ([]j.Leaf, error){
+		func() ([]j.Leaf, error) { return j.NewMapper(j.NewConfig()).Do("doc", b) },
+		func() ([]j.Leaf, error) { return j.NewMapper(j.NewConfig()).DoReader("doc", bytes.NewReader(b)) },
+	} {
+		ls, err := do()
+		if err != nil {
+			t.Error(err)
+		}
+
+		indexes := make(map[string]int)
+		for _, l := range ls {
+			if l.Name == "_tree" {
+				continue
+			}
+			if l.HasIndex {
+				indexes[fmt.Sprintf("%v", l.Value)] = l.Index
+			}
+		}
+
+		assert.Equal(t, map[string]int{
+			"a": 0,
+			"b": 0,
+			"c": 1,
+			"d": 0,
+			"e": 1,
+			"f": 0,
+		}, indexes)
+	}
+}
+
+func TestKeepEmpty(t *testing.T) {
+	b := []byte(`{"foo": "foo_v", "bar": null, "baz": {}, "qux": [], "items": [null, {}, []]}`)
+
+	ls, err := j.NewMapper(j.NewConfig()).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 2, len(ls))
+
+	c := j.Config{KeepEmpty: true}
+
+	for _, do := range []func() ([]j.Leaf, error){
+		func() ([]j.Leaf, error) { return j.NewMapper(c).Do("doc", b) },
+		func() ([]j.Leaf, error) { return j.NewMapper(c).DoReader("doc", bytes.NewReader(b)) },
+	} {
+		ls, err := do()
+		if err != nil {
+			t.Error(err)
+		}
+
+		var rows []string
+		for _, l := range ls {
+			if l.Name == "_tree" {
+				continue
+			}
+
+			assert.Equal(t, l.DataType == "string", l.Value != nil)
+			rows = append(rows, fmt.Sprintf("%s|%s|%s|%d|%t", l.Name, l.Path, l.DataType, l.Index, l.HasIndex))
+		}
+		sort.Strings(rows)
+
+		assert.Equal(t, []string{
+			"doc__items|val|array|2|true",
+			"doc__items|val|null|0|true",
+			"doc__items|val|object|1|true",
+			"doc|bar|null|0|false",
+			"doc|baz|object|0|false",
+			"doc|foo|string|0|false",
+			"doc|qux|array|0|false",
+		}, rows)
+	}
+
+	ls, err = j.NewMapper(c).DoPath("doc", "bar", b)
+	if err != nil {
+		t.Error(err)
+	}
+	if assert.NotEmpty(t, ls) {
+		assert.Equal(t, "null", ls[0].DataType)
+	}
+
+	ls, err = j.NewMapper(c).DoPath("doc", "missing.path", b)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Empty(t, ls)
+}
+
+func TestUseNumber(t *testing.T) {
+	b := []byte(`{"id": 1234567890123456789, "big": 123456789012345678901234567890, "price": 12.50, "n": [1e3]}`)
+
+	for _, do := range []func() ([]j.Leaf, error){
+		func() ([]j.Leaf, error) { return j.NewMapper(j.Config{UseNumber: true}).Do("doc", b) },
+		func() ([]j.Leaf, error) {
+			return j.NewMapper(j.Config{UseNumber: true}).DoReader("doc", bytes.NewReader(b))
+		},
+	} {
+		ls, err := do()
+		if err != nil {
+			t.Error(err)
+		}
+
+		values := make(map[string]string)
+		for _, l := range ls {
+			if l.Name == "_tree" {
+				continue
+			}
+			values[l.Name+"|"+l.Path] = fmt.Sprintf("%s %v %T", l.DataType, l.Value, l.Value)
+		}
+
+		assert.Equal(t, map[string]string{
+			"doc|id":     "integer 1234567890123456789 json.Number",
+			"doc|big":    "decimal 123456789012345678901234567890 json.Number",
+			"doc|price":  "decimal 12.50 json.Number",
+			"doc__n|val": "decimal 1e3 json.Number",
+		}, values)
+	}
+
+	ls, err := j.NewMapper(j.NewConfig()).Do("doc", b)
+	if err != nil {
+		t.Error(err)
+	}
+	for _, l := range ls {
+		if l.Path == "id" {
+			assert.Equal(t, "float64", l.DataType)
+		}
+	}
+}
+
+func TestLineage(t *testing.T) {
+	b := []byte(`{"foo": "foo_v", "bar": [{"n": 1, "baz": [{"x": "a"}]}], "qux": [["b"]]}`)
+
+	c := j.NewConfig()
+	c.TableNames = []string{"tbl"}
+	b2 := []byte(`{"tbl": {"y": "c"}}`)
+
+	for _, do := range []func() ([]j.Leaf, error){
+		func() ([]j.Leaf, error) { return j.NewMapper(j.NewConfig()).Do("doc", b) },
+		func() ([]j.Leaf, error) { return j.NewMapper(j.NewConfig()).DoReader("doc", bytes.NewReader(b)) },
+		func() ([]j.Leaf, error) { return j.NewMapper(c).Do("doc", b2) },
+	} {
+		ls, err := do()
+		if err != nil {
+			t.Error(err)
+		}
+
+		lineages := make(map[string][]string)
+		for _, l := range ls {
+			lineages[l.ID] = l.Lineage
+		}
+
+		depths := make(map[string]int)
+		for _, l := range ls {
+			// the lineage ends with the parent, after the parent's lineage
+			if l.ParentID == "" {
+				assert.Empty(t, l.Lineage)
+			} else if assert.NotEmpty(t, l.Lineage) {
+				n := len(l.Lineage) - 1
+				assert.Equal(t, l.ParentID, l.Lineage[n])
+				if up, ok := lineages[l.ParentID]; ok && n > 0 {
+					assert.Equal(t, up, l.Lineage[:n])
+				} else if ok {
+					assert.Empty(t, up)
+				}
+			}
+
+			if l.Name != "_tree" {
+				depths[fmt.Sprintf("%v", l.Value)] = len(l.Lineage)
+			}
+		}
+
+		for v, depth := range map[string]int{"foo_v": 0, "a": 2, "b": 2, "c": 1} {
+			if d, ok := depths[v]; ok {
+				assert.Equal(t, depth, d, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package json2Leaf
+
+import "fmt"
+
+// Rebuild reconstructs the document that leaves were mapped from, using the
+// Location of each leaf, so it is unaffected by TableNames splits, overrides
+// and substitutions. Leaves from a subtree rebuild to a document holding just
+// that subtree.
+//
+// Nulls and empty containers are only restored when the leaves were mapped
+// with KeepEmpty; otherwise missing array elements come back as nulls and
+// empty containers are dropped.
+func Rebuild(ls []Leaf) (doc interface{}, err error) {
+	for _, l := range ls {
+		if l.Name == "_tree" {
+			continue
+		}
+
+		if l.Location == "" {
+			return nil, fmt.Errorf("json2Leaf: leaf %s/%s has no location", l.Name, l.Path)
+		}
+
+		segs, err := parseLoc(l.Location)
+		if err != nil {
+			return nil, fmt.Errorf("json2Leaf: %v", err)
+		}
+
+		if doc, err = insert(doc, segs, rebuildValue(l)); err != nil {
+			return nil, fmt.Errorf("json2Leaf: %s: %v", l.Location, err)
+		}
+	}
+
+	return
+}
+
+func rebuildValue(l Leaf) interface{} {
+	switch l.DataType {
+	case "object":
+		return make(map[string]interface{})
+	case "array":
+		return []interface{}{}
+	}
+
+	return l.Value
+}
+
+// insert sets v at segs below into, creating containers on the way, and
+// returns into or its replacement.
+func insert(into interface{}, segs []segment, v interface{}) (interface{}, error) {
+	if len(segs) == 0 {
+		if into != nil {
+			return nil, fmt.Errorf("value is mapped more than once")
+		}
+
+		return v, nil
+	}
+
+	seg := segs[0]
+
+	if seg.key {
+		m, ok := into.(map[string]interface{})
+		if into == nil {
+			m, ok = make(map[string]interface{}), true
+		}
+		if !ok {
+			return nil, fmt.Errorf("expected an object, found %T", into)
+		}
+
+		child, err := insert(m[seg.name], segs[1:], v)
+		if err != nil {
+			return nil, err
+		}
+		m[seg.name] = child
+
+		return m, nil
+	}
+
+	a, ok := into.([]interface{})
+	if into == nil {
+		ok = true
+	}
+	if !ok {
+		return nil, fmt.Errorf("expected an array, found %T", into)
+	}
+
+	for len(a) <= seg.index {
+		a = append(a, nil)
+	}
+
+	child, err := insert(a[seg.index], segs[1:], v)
+	if err != nil {
+		return nil, err
+	}
+	a[seg.index] = child
+
+	return a, nil
+}
@@ -0,0 +1,82 @@
+package json2Leaf_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebuild(t *testing.T) {
+	b := []byte(`
+	{
+		"Foo-Bar": "foo_v",
+		"it's": {"a\tb": 1.5, "c": [true, false]},
+		"ObjectA": {"SubObject": {"x": "y"}},
+		"items": [
+			{"price": 1, "tags": ["a", "b"]},
+			{"price": 2, "tags": [], "extra": {}},
+			null,
+			[[1], [2, 3]]
+		],
+		"nil": null
+	}`)
+
+	var expected interface{}
+	if err := json.Unmarshal(b, &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	c := j.Config{
+		TableNames:      []string{"ObjectA__SubObject"},
+		ColumnOverrides: [][]string{{"doc__items", "price", "prices", "amount"}},
+		ColumnSubs:      [][]string{{"foo", "f"}},
+		TableSubs:       [][]string{{"doc", "d"}},
+		KeepEmpty:       true,
+	}
+
+	ls, err := j.NewMapper(c).Do("doc", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := j.Rebuild(ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, actual)
+
+	ls, err = j.NewMapper(c).DoReader("doc", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err = j.Rebuild(ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, actual)
+
+	var subtree []j.Leaf
+	for _, l := range ls {
+		if strings.HasPrefix(l.Location, "$['items'][0]") {
+			subtree = append(subtree, l)
+		}
+	}
+
+	actual, err = j.Rebuild(subtree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"price": 1.0, "tags": []interface{}{"a", "b"}},
+		},
+	}, actual)
+
+	_, err = j.Rebuild(append(ls, ls[0]))
+	assert.Error(t, err)
+}
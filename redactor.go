@@ -11,8 +11,69 @@ var (
 func Redact(str string) (r string, ok bool) {
 	r = str
 	if ok = PersonNameRe.MatchString(str); ok {
-		r = PersonNameRe.ReplaceAllString(str, "[PII redacted]")
+		r = PersonNameRe.ReplaceAllString(str, redacted)
 	}
 
 	return
 }
+
+const redacted = "[PII redacted]"
+
+// RedactionRule replaces what Pattern matches in the string values of leaves
+// with With. Table and Path limit it to leaves with that name and path, and
+// match any when empty. Pattern defaults to PersonNameRe and With to
+// "[PII redacted]".
+type RedactionRule struct {
+	Table   string
+	Path    string
+	Pattern *regexp.Regexp
+	With    string
+}
+
+func NewRedactor(rules []RedactionRule) *Redactor {
+	r := &Redactor{rules: make([]RedactionRule, len(rules))}
+	for i, rule := range rules {
+		if rule.Pattern == nil {
+			rule.Pattern = PersonNameRe
+		}
+		if rule.With == "" {
+			rule.With = redacted
+		}
+		r.rules[i] = rule
+	}
+
+	return r
+}
+
+// Redactor applies RedactionRules to leaves. _tree leaves hold node names
+// rather than values, so they're left alone.
+type Redactor struct {
+	rules []RedactionRule
+}
+
+func (t Redactor) Do(ls []Leaf) (r []Leaf) {
+	for _, l := range ls {
+		r = append(r, t.redact(l))
+	}
+
+	return
+}
+
+func (t Redactor) redact(l Leaf) Leaf {
+	if s, ok := l.Value.(string); ok && l.Name != "_tree" {
+		for _, rule := range t.rules {
+			if (rule.Table == "" || rule.Table == l.Name) && (rule.Path == "" || rule.Path == l.Path) {
+				s = rule.Pattern.ReplaceAllString(s, rule.With)
+			}
+		}
+		l.Value = s
+	}
+
+	return l
+}
+
+// Sink returns a LeafSink that redacts each leaf before passing it on to
+// next.
+func (t Redactor) Sink(next LeafSink) LeafSink {
+	return MapSink(t.redact, next)
+}
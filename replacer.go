@@ -21,18 +21,23 @@ type Replacer struct {
 
 func (t Replacer) Do(ls []Leaf) (r []Leaf) {
 	for _, l := range ls {
-		l.Name = t.get(l.Name)
-		l.Path = t.get(l.Path)
-		switch l.Value.(type) {
-		case string:
-			l.Value = t.get(l.Value.(string))
-		}
-		r = append(r, l)
+		r = append(r, t.replace(l))
 	}
 
 	return
 }
 
+func (t Replacer) replace(l Leaf) Leaf {
+	l.Name = t.get(l.Name)
+	l.Path = t.get(l.Path)
+	switch l.Value.(type) {
+	case string:
+		l.Value = t.get(l.Value.(string))
+	}
+
+	return l
+}
+
 func (t Replacer) get(str string) string {
 	lower := strings.ToLower(str)
 	res, ok := t.data[lower]
@@ -42,3 +47,9 @@ func (t Replacer) get(str string) string {
 
 	return str
 }
+
+// Sink returns a LeafSink that replaces values in each leaf before passing it
+// on to next.
+func (t Replacer) Sink(next LeafSink) LeafSink {
+	return MapSink(t.replace, next)
+}
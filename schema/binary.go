@@ -0,0 +1,254 @@
+package schema
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// pgCopySignature starts every binary COPY file, followed by the flags and
+// header extension length.
+var pgCopySignature = []byte("PGCOPY\n\377\r\n\x00")
+
+// pgEpoch is where PostgreSQL counts dates and timestamps from.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var numericRe = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// timestampLayouts are those recognised by json2Leaf.DetectTimestamp, and
+// dates, which may share a column with timestamps.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02 15:04:05.999999999Z0700",
+	"2006-01-02",
+}
+
+// binaryRows writes rows to a file in PostgreSQL's binary COPY format, and a
+// COPY statement loading that file to the script. COPY reads the file on the
+// database server, so it must be run there, or rewritten as psql's \copy.
+type binaryRows struct {
+	f     *os.File
+	b     *bufio.Writer
+	types []string
+	buf   []byte
+	nuls  int
+}
+
+func newBinaryRows(w io.Writer, dir, name, table string, columns []columnDef) (*binaryRows, error) {
+	path, err := filepath.Abs(filepath.Join(dir, cleanFileName(name)+".bin"))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &binaryRows{f: f, b: bufio.NewWriter(f)}
+	for _, c := range columns {
+		r.types = append(r.types, c.sqlType)
+	}
+
+	r.b.Write(pgCopySignature)
+	r.b.Write(make([]byte, 8))
+
+	if _, err := fmt.Fprintf(w, "COPY %s (%s) FROM %s WITH (FORMAT binary);\n",
+		table, strings.Join(columnNames(columns), ", "), quoteString(path)); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *binaryRows) WriteRow(values []interface{}) error {
+	if len(values) != len(r.types) {
+		return fmt.Errorf("got %d values for %d columns", len(values), len(r.types))
+	}
+
+	r.buf = binary.BigEndian.AppendUint16(r.buf[:0], uint16(len(values)))
+	for i, v := range values {
+		if v == nil {
+			r.buf = binary.BigEndian.AppendUint32(r.buf, 0xffffffff)
+			continue
+		}
+
+		start := len(r.buf)
+		r.buf = append(r.buf, 0, 0, 0, 0)
+
+		var err error
+		r.buf, err = r.appendValue(r.buf, v, r.types[i])
+		if err != nil {
+			return err
+		}
+
+		binary.BigEndian.PutUint32(r.buf[start:], uint32(len(r.buf)-start-4))
+	}
+
+	_, err := r.b.Write(r.buf)
+	return err
+}
+
+func (r *binaryRows) Close() error {
+	r.b.Write([]byte{0xff, 0xff})
+
+	if err := r.b.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+
+	return r.f.Close()
+}
+
+func (r *binaryRows) DroppedNULs() int {
+	return r.nuls
+}
+
+// appendValue appends the binary encoding of v as sqlType, which is what the
+// column's receive function expects, so types must match exactly.
+func (r *binaryRows) appendValue(b []byte, v interface{}, sqlType string) ([]byte, error) {
+	s := fmt.Sprintf("%v", v)
+
+	switch sqlType {
+	case "BIGINT", "INTEGER":
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if sqlType == "INTEGER" {
+			return binary.BigEndian.AppendUint32(b, uint32(int32(i))), nil
+		}
+		return binary.BigEndian.AppendUint64(b, uint64(i)), nil
+
+	case "NUMERIC":
+		return appendNumeric(b, s)
+
+	case "BOOLEAN":
+		if t, ok := v.(bool); ok && t || strings.EqualFold(s, "true") {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+
+	case "UUID":
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, id[:]...), nil
+
+	case "DATE", "TIMESTAMPTZ":
+		for _, layout := range timestampLayouts {
+			t, err := time.Parse(layout, s)
+			if err != nil {
+				continue
+			}
+			secs := t.Unix() - pgEpoch.Unix()
+			if sqlType == "DATE" {
+				return binary.BigEndian.AppendUint32(b, uint32(int32(secs/86400))), nil
+			}
+			return binary.BigEndian.AppendUint64(b, uint64(secs*1000000+int64(t.Nanosecond()/1000))), nil
+		}
+		return nil, fmt.Errorf("can't parse %q as %s", s, sqlType)
+
+	case "LTREE":
+		// a version number, then the text
+		b = append(b, 1)
+	}
+
+	// text and varchar are sent as is, apart from NULs
+	r.nuls += strings.Count(s, "\x00")
+	return append(b, strings.ReplaceAll(s, "\x00", "")...), nil
+}
+
+// appendNumeric appends a decimal number in PostgreSQL's numeric format: the
+// number of base 10000 digits, the weight of the first digit, the sign, the
+// decimal places to display, then the digits.
+func appendNumeric(b []byte, s string) ([]byte, error) {
+	if !numericRe.MatchString(s) {
+		return nil, fmt.Errorf("can't parse %q as NUMERIC", s)
+	}
+
+	sign := uint16(0)
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = 0x4000
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	exp := 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		if e > 131072 || e < -16383 {
+			return nil, fmt.Errorf("%q is out of range for NUMERIC", s)
+		}
+		exp = e
+		s = s[:i]
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+
+	// move the decimal point by the exponent
+	digits := intPart + fracPart
+	point := len(intPart) + exp
+	if point < 0 {
+		digits = strings.Repeat("0", -point) + digits
+		point = 0
+	}
+	if point > len(digits) {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+	intPart, fracPart = digits[:point], digits[point:]
+	dscale := len(fracPart)
+
+	// pad both parts to whole base 10000 digits around the point
+	intPart = strings.Repeat("0", (4-len(intPart)%4)%4) + intPart
+	fracPart += strings.Repeat("0", (4-len(fracPart)%4)%4)
+	weight := len(intPart)/4 - 1
+
+	var groups []uint16
+	for i := 0; i < len(intPart+fracPart); i += 4 {
+		g, _ := strconv.Atoi((intPart + fracPart)[i : i+4])
+		groups = append(groups, uint16(g))
+	}
+
+	for len(groups) > 0 && groups[0] == 0 {
+		groups = groups[1:]
+		weight--
+	}
+	for len(groups) > 0 && groups[len(groups)-1] == 0 {
+		groups = groups[:len(groups)-1]
+	}
+	if len(groups) == 0 {
+		weight, sign = 0, 0
+	}
+
+	b = binary.BigEndian.AppendUint16(b, uint16(len(groups)))
+	b = binary.BigEndian.AppendUint16(b, uint16(int16(weight)))
+	b = binary.BigEndian.AppendUint16(b, sign)
+	b = binary.BigEndian.AppendUint16(b, uint16(dscale))
+	for _, g := range groups {
+		b = binary.BigEndian.AppendUint16(b, g)
+	}
+
+	return b, nil
+}
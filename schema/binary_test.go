@@ -0,0 +1,140 @@
+package schema_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/jbrough/json2Leaf/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+// readBinaryCopy parses a binary COPY file into the raw bytes of each field,
+// with nil for NULL.
+func readBinaryCopy(t *testing.T, path string) [][][]byte {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := append([]byte("PGCOPY\n\377\r\n\x00"), make([]byte, 8)...)
+	if !bytes.HasPrefix(b, header) {
+		t.Fatalf("bad header % x", b[:len(header)])
+	}
+	b = b[len(header):]
+
+	var rows [][][]byte
+	for {
+		n := int16(binary.BigEndian.Uint16(b))
+		b = b[2:]
+		if n == -1 {
+			break
+		}
+
+		var row [][]byte
+		for i := int16(0); i < n; i++ {
+			size := int32(binary.BigEndian.Uint32(b))
+			b = b[4:]
+			if size < 0 {
+				row = append(row, nil)
+				continue
+			}
+			row = append(row, b[:size])
+			b = b[size:]
+		}
+		rows = append(rows, row)
+	}
+	assert.Empty(t, b, "data after trailer")
+
+	return rows
+}
+
+func numeric(ndigits, weight int16, sign, dscale uint16, digits ...uint16) []byte {
+	var b []byte
+	for _, v := range append([]uint16{uint16(ndigits), uint16(weight), sign, dscale}, digits...) {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+
+	return b
+}
+
+func TestBinaryNodes(t *testing.T) {
+	dir := t.TempDir()
+	g := schema.NewGenerator()
+	g.BinaryDir = dir
+	g.TreePath = true
+
+	out := generate(t, g, j.Config{}, `{"a": ["x\ty"]}`)
+
+	path := filepath.Join(dir, "nodes.bin")
+	assert.Contains(t, out, "COPY nodes (id, parent_id, name, path, data_type, value, ord, location, semantic_type, ingest_id, source, content_hash, tree_path) FROM '"+path+"' WITH (FORMAT binary);\n")
+	assert.NotContains(t, out, "FROM stdin")
+
+	rows := readBinaryCopy(t, path)
+	if assert.Len(t, rows, 2) {
+		row := rows[0]
+		assert.Len(t, row, 13)
+		assert.Equal(t, "doc__a", string(row[2]))
+		assert.Equal(t, "x\ty", string(row[5]))
+		assert.Equal(t, []byte{0, 0, 0, 0}, row[6])
+		assert.Equal(t, "$['a'][0]", string(row[7]))
+		assert.Nil(t, row[8])
+		// ltree's version number
+		assert.Equal(t, byte(1), row[12][0])
+		assert.Equal(t, strings.ReplaceAll(string(row[1])+"."+string(row[0]), "-", "_"), string(row[12][1:]))
+	}
+}
+
+func TestBinaryRelational(t *testing.T) {
+	dir := t.TempDir()
+	g := schema.NewGenerator()
+	g.BinaryDir = dir
+	g.Relational = true
+
+	ls, err := j.NewMapper(j.Config{UseNumber: true}).Do("doc", []byte(`{
+		"n": 12345.678, "i": 42, "ok": true, "tiny": 0.0001, "big": 1e21, "neg": -10000.5, "zero": 0,
+		"d": "2000-01-02", "ts": "2000-01-01T01:00:01.5+01:00", "u": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := generateLeaves(t, g, j.NewTypeDetector().Do(ls))
+	assert.Contains(t, out, `COPY "doc" (_id, _parent_id, _ord, "big", "d", "i", "n", "neg", "ok", "tiny", "ts", "u", "zero", _ingest_id, _source, _content_hash) FROM '`)
+
+	rows := readBinaryCopy(t, filepath.Join(dir, "doc.bin"))
+	if !assert.Len(t, rows, 1) {
+		return
+	}
+	row := rows[0]
+
+	assert.Nil(t, row[1])
+	assert.Nil(t, row[2])
+	assert.Equal(t, numeric(1, 5, 0, 0, 10), row[3])
+	assert.Equal(t, []byte{0, 0, 0, 1}, row[4])
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 42}, row[5])
+	assert.Equal(t, numeric(3, 1, 0, 3, 1, 2345, 6780), row[6])
+	assert.Equal(t, numeric(3, 1, 0x4000, 1, 1, 0, 5000), row[7])
+	assert.Equal(t, []byte{1}, row[8])
+	assert.Equal(t, numeric(1, -1, 0, 4, 1), row[9])
+	assert.Equal(t, binary.BigEndian.AppendUint64(nil, 1500000), row[10])
+	assert.Equal(t, []byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}, row[11])
+	assert.Equal(t, numeric(0, 0, 0, 0), row[12])
+}
+
+func TestBinaryDialect(t *testing.T) {
+	g := schema.NewGenerator()
+	g.BinaryDir = t.TempDir()
+	g.Dialect = schema.SQLite{}
+
+	ls, err := j.NewMapper(j.Config{}).Do("doc", []byte(`{"a": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, g.WriteLeaf(ls[0]))
+}
@@ -0,0 +1,165 @@
+package schema
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/jbrough/json2Leaf"
+)
+
+// closureColumns are those of node_closure, which has a row for every node
+// and each of its ancestors, and one for the node itself at depth 0.
+var closureColumns = []columnDef{
+	{"ancestor_id", "VARCHAR", "NOT NULL"},
+	{"descendant_id", "VARCHAR", "NOT NULL"},
+	{"depth", "INTEGER", "NOT NULL"},
+}
+
+var closureKey = []string{"ancestor_id", "descendant_id"}
+
+// closureStaging is loaded in append mode, then merged into node_closure.
+const closureStaging = "node_closure_staging"
+
+// closureTable creates node_closure and its index for finding the ancestors of
+// a node.
+func (g *Generator) closureTable() string {
+	constraint, statement := g.Dialect.Index("node_closure_descendant_id", "node_closure", []string{"descendant_id"})
+
+	constraints := []string{g.Dialect.PrimaryKey(closureKey)}
+	if constraint != "" {
+		constraints = append(constraints, constraint)
+	}
+
+	return createTable(g.Dialect, "node_closure", closureColumns, constraints...) + statement
+}
+
+// writeClosure adds the closure rows of the leaf's node the first time one of
+// its leaves is written. The rows can't go in the script while a block of
+// nodes rows is open, so they're spooled to a temporary file until Close.
+func (g *Generator) writeClosure(leaf json2Leaf.Leaf) error {
+	if g.closed[leaf.ID] {
+		return nil
+	}
+	g.closed[leaf.ID] = true
+
+	// a node's parent can be written after it, so nodes mapped without
+	// a Lineage wait for every parent to be known
+	if len(leaf.Lineage) == 0 && leaf.ParentID != "" {
+		g.parents[leaf.ID] = leaf.ParentID
+		g.pending = append(g.pending, leaf.ID)
+		return nil
+	}
+
+	return g.writeClosureRows(leaf.ID, leaf.Lineage)
+}
+
+// writeClosureRows writes the rows of the node id with its ancestors, root
+// first.
+func (g *Generator) writeClosureRows(id string, ancestors []string) error {
+	if g.closure == nil {
+		table := "node_closure"
+		if g.Append {
+			table = closureStaging
+		}
+
+		// SQLLoader's rows aren't written anywhere
+		var w io.Writer
+		if g.load == nil {
+			f, err := os.CreateTemp("", "node_closure")
+			if err != nil {
+				return err
+			}
+			g.closureFile = f
+			g.closureSpool = bufio.NewWriter(f)
+			w = g.closureSpool
+		}
+
+		rows, err := g.newRows(w, table, table, closureColumns)
+		if err != nil {
+			return err
+		}
+		g.closure = rows
+	}
+
+	if err := g.closure.WriteRow([]interface{}{id, id, 0}); err != nil {
+		return err
+	}
+	for i, a := range ancestors {
+		if err := g.closure.WriteRow([]interface{}{a, id, len(ancestors) - i}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parentChain follows the parents of id to the first node without one, and
+// returns the ids it passed, root first.
+func (g *Generator) parentChain(id string) (r []string) {
+	seen := map[string]bool{id: true}
+	for p := g.parents[id]; p != "" && !seen[p]; p = g.parents[p] {
+		seen[p] = true
+		r = append(r, p)
+	}
+
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+
+	return
+}
+
+// closeClosure writes the closure rows after those of nodes.
+func (g *Generator) closeClosure() error {
+	for _, id := range g.pending {
+		if err := g.writeClosureRows(id, g.parentChain(id)); err != nil {
+			return err
+		}
+	}
+
+	if g.closure == nil {
+		return nil
+	}
+
+	if err := g.closeRows(g.closure); err != nil {
+		return err
+	}
+
+	if _, err := g.Writer.WriteString("\n"); err != nil {
+		return err
+	}
+	if err := g.unspoolClosure(); err != nil {
+		return err
+	}
+
+	if g.Append {
+		_, err := g.Writer.WriteString("\n" + g.Dialect.Merge("node_closure", closureStaging, columnNames(closureColumns), closureKey) +
+			g.Dialect.DropTable(closureStaging))
+		return err
+	}
+
+	return nil
+}
+
+// unspoolClosure copies the spooled closure rows to the script, and removes
+// their file.
+func (g *Generator) unspoolClosure() error {
+	f := g.closureFile
+	if f == nil {
+		return nil
+	}
+	g.closureFile = nil
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := g.closureSpool.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := g.Writer.ReadFrom(f)
+
+	return err
+}
@@ -0,0 +1,416 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/jbrough/json2Leaf"
+)
+
+// ColumnarFormat is a file format written by ColumnarWriter.
+type ColumnarFormat int
+
+const (
+	Parquet ColumnarFormat = iota
+	ArrowIPC
+)
+
+func (f ColumnarFormat) ext() string {
+	if f == ArrowIPC {
+		return ".arrow"
+	}
+
+	return ".parquet"
+}
+
+// ColumnarWriter writes leaves as Parquet or Arrow IPC files into Dir, either
+// as nodes.parquet with the same columns as the nodes table, or with a file
+// per table in relational mode.
+type ColumnarWriter struct {
+	Dir    string
+	Format ColumnarFormat
+	// Relational writes a file per node name with a typed column per path.
+	// As with Generator, leaves are held in memory until Close.
+	Relational bool
+	// RowGroupSize is the most rows buffered before they are written, 64k
+	// when not set. Each WriteLeaves call also ends a row group.
+	RowGroupSize int
+	mu           sync.Mutex
+	nodes        *recordFile
+	builder      *array.RecordBuilder
+	tables       *TableSet
+}
+
+const defaultRowGroupSize = 64 * 1024
+
+func NewColumnarWriter(dir string, format ColumnarFormat) *ColumnarWriter {
+	return &ColumnarWriter{
+		Dir:          dir,
+		Format:       format,
+		RowGroupSize: defaultRowGroupSize,
+	}
+}
+
+func (w *ColumnarWriter) rowGroupSize() int {
+	if w.RowGroupSize <= 0 {
+		return defaultRowGroupSize
+	}
+
+	return w.RowGroupSize
+}
+
+var nodesSchema = func() *arrow.Schema {
+	var fields []arrow.Field
+	for _, c := range nodeColumns {
+		t := arrow.DataType(arrow.BinaryTypes.String)
+		if c.sqlType == "INTEGER" {
+			t = arrow.PrimitiveTypes.Int64
+		}
+
+		fields = append(fields, arrow.Field{Name: c.name, Type: t, Nullable: c.constraint == ""})
+	}
+
+	return arrow.NewSchema(fields, nil)
+}()
+
+func (w *ColumnarWriter) WriteLeaves(leaves []json2Leaf.Leaf) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, leaf := range leaves {
+		if err := w.writeLeaf(leaf); err != nil {
+			return err
+		}
+	}
+
+	return w.flush()
+}
+
+// WriteLeaf writes a single leaf.
+func (w *ColumnarWriter) WriteLeaf(leaf json2Leaf.Leaf) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writeLeaf(leaf)
+}
+
+func (w *ColumnarWriter) writeLeaf(leaf json2Leaf.Leaf) error {
+	if w.Relational {
+		if w.tables == nil {
+			w.tables = NewTableSet()
+		}
+		w.tables.Add(leaf)
+
+		return nil
+	}
+
+	if w.nodes == nil {
+		f, err := w.create("nodes", nodesSchema)
+		if err != nil {
+			return err
+		}
+		w.nodes = f
+		w.builder = array.NewRecordBuilder(memory.DefaultAllocator, nodesSchema)
+	}
+
+	for i, v := range nodeRow(leaf) {
+		if err := appendValue(w.builder.Field(i), v); err != nil {
+			return err
+		}
+	}
+
+	if w.builder.Field(0).Len() >= w.rowGroupSize() {
+		return w.flush()
+	}
+
+	return nil
+}
+
+func (w *ColumnarWriter) flush() error {
+	if w.builder == nil || w.builder.Field(0).Len() == 0 {
+		return nil
+	}
+
+	rec := w.builder.NewRecord()
+	defer rec.Release()
+
+	return w.nodes.w.Write(rec)
+}
+
+func (w *ColumnarWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.Relational {
+		return w.writeTables()
+	}
+
+	if w.nodes == nil {
+		return nil
+	}
+
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.builder.Release()
+
+	return w.nodes.Close()
+}
+
+func (w *ColumnarWriter) writeTables() error {
+	if w.tables == nil {
+		return nil
+	}
+
+	for _, t := range w.tables.Tables() {
+		fields := []arrow.Field{
+			{Name: "_id", Type: arrow.BinaryTypes.String},
+			{Name: "_parent_id", Type: arrow.BinaryTypes.String, Nullable: true},
+			{Name: "_ord", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		}
+		for _, c := range t.Columns {
+			fields = append(fields, arrow.Field{Name: c.Name, Type: columnType(c), Nullable: true})
+		}
+		s := arrow.NewSchema(fields, nil)
+
+		f, err := w.create(t.Name, s)
+		if err != nil {
+			return err
+		}
+
+		b := array.NewRecordBuilder(memory.DefaultAllocator, s)
+
+		write := func() error {
+			rec := b.NewRecord()
+			defer rec.Release()
+
+			return f.w.Write(rec)
+		}
+
+		for i, r := range t.Rows {
+			values := []interface{}{r.ID, nullable(r.ParentID), ordValue(r.Index, r.HasIndex)}
+			for _, c := range t.Columns {
+				values = append(values, arrowValue(r.Values[c.Path], s.Field(len(values)).Type))
+			}
+
+			for j, v := range values {
+				if err := appendValue(b.Field(j), v); err != nil {
+					return fmt.Errorf("%s.%s: %v", t.Name, s.Field(j).Name, err)
+				}
+			}
+
+			if (i+1)%w.rowGroupSize() == 0 {
+				if err := write(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if b.Field(0).Len() > 0 || len(t.Rows) == 0 {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+		b.Release()
+
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type recordWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+// recordFile is a Parquet or Arrow IPC writer and the file it writes to.
+type recordFile struct {
+	w recordWriter
+	f *os.File
+}
+
+func (f *recordFile) Close() error {
+	if err := f.w.Close(); err != nil {
+		f.f.Close()
+		return err
+	}
+
+	return f.f.Close()
+}
+
+func (w *ColumnarWriter) create(name string, s *arrow.Schema) (*recordFile, error) {
+	f, err := os.Create(filepath.Join(w.Dir, cleanFileName(name)+w.Format.ext()))
+	if err != nil {
+		return nil, err
+	}
+
+	var rw recordWriter
+	switch w.Format {
+	case ArrowIPC:
+		rw, err = ipc.NewFileWriter(f, ipc.WithSchema(s), ipc.WithAllocator(memory.DefaultAllocator))
+	default:
+		props := parquet.NewWriterProperties(
+			parquet.WithCompression(compress.Codecs.Snappy),
+			parquet.WithMaxRowGroupLength(int64(w.rowGroupSize())),
+		)
+		// hide Close from the parquet writer, which would otherwise close f
+		rw, err = pqarrow.NewFileWriter(s, struct{ io.Writer }{f}, props, pqarrow.DefaultWriterProps())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &recordFile{rw, f}, nil
+}
+
+// cleanFileName keeps table names usable as file names.
+func cleanFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func arrowType(dataType string) arrow.DataType {
+	switch dataType {
+	case "float64":
+		return arrow.PrimitiveTypes.Float64
+	case "bool", "boolean":
+		return arrow.FixedWidthTypes.Boolean
+	case "integer":
+		return arrow.PrimitiveTypes.Int64
+	}
+
+	return arrow.BinaryTypes.String
+}
+
+// columnType is arrowType for a table column. Decimals get a Decimal128 of
+// the digits they need, so they're kept exactly, or are strings when they
+// need more than it can hold.
+func columnType(c Column) arrow.DataType {
+	if c.DataType != "decimal" {
+		return arrowType(c.DataType)
+	}
+	if c.Precision > maxDecimalPrecision {
+		return arrow.BinaryTypes.String
+	}
+
+	return &arrow.Decimal128Type{Precision: int32(c.Precision), Scale: int32(c.Scale)}
+}
+
+// arrowValue converts a value for a column of type t, like cellValue does for
+// SQL columns.
+func arrowValue(v Value, t arrow.DataType) interface{} {
+	if v.Value == nil {
+		return nil
+	}
+
+	switch t.ID() {
+	case arrow.FLOAT64, arrow.INT64, arrow.DECIMAL128:
+		return cellValue(v, "NUMERIC")
+	case arrow.BOOL:
+		return cellValue(v, "BOOLEAN")
+	}
+
+	return textValue(v.Value, v.DataType)
+}
+
+func appendValue(b array.Builder, v interface{}) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		b.Append(fmt.Sprintf("%v", v))
+		return nil
+
+	case *array.BooleanBuilder:
+		if t, ok := v.(bool); ok {
+			b.Append(t)
+			return nil
+		}
+
+	case *array.Int64Builder:
+		switch t := v.(type) {
+		case int:
+			b.Append(int64(t))
+			return nil
+		case json.Number:
+			i, err := t.Int64()
+			if err != nil {
+				return err
+			}
+			b.Append(i)
+			return nil
+		case string:
+			i, err := strconv.ParseInt(t, 10, 64)
+			if err != nil {
+				return err
+			}
+			b.Append(i)
+			return nil
+		}
+
+	case *array.Decimal128Builder:
+		var s string
+		switch t := v.(type) {
+		case json.Number:
+			s = string(t)
+		case string:
+			s = t
+		}
+
+		if s != "" {
+			dt := b.Type().(*arrow.Decimal128Type)
+			n, err := decimal128.FromString(s, dt.Precision, dt.Scale)
+			if err != nil {
+				return err
+			}
+			b.Append(n)
+			return nil
+		}
+
+	case *array.Float64Builder:
+		switch t := v.(type) {
+		case json.Number:
+			f, err := t.Float64()
+			if err != nil {
+				return err
+			}
+			b.Append(f)
+			return nil
+		case string:
+			f, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return err
+			}
+			b.Append(f)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("can't write %T to a %s column", v, b.Type())
+}
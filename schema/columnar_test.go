@@ -0,0 +1,194 @@
+package schema_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	j "github.com/jbrough/json2Leaf"
+	"github.com/jbrough/json2Leaf/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+// readParquet returns the number of row groups and the contents of a Parquet
+// file as a single table.
+func readParquet(t *testing.T, path string) (int, arrow.Table) {
+	pf, err := file.OpenParquetFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pf.Close() })
+
+	r, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tbl, err := r.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(tbl.Release)
+
+	return pf.NumRowGroups(), tbl
+}
+
+func column(t *testing.T, tbl arrow.Table, name string) arrow.Array {
+	idx := tbl.Schema().FieldIndices(name)
+	if len(idx) == 0 {
+		t.Fatalf("no column %s", name)
+	}
+
+	return tbl.Column(idx[0]).Data().Chunk(0)
+}
+
+func TestParquetNodes(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewColumnarWriter(dir, schema.Parquet)
+
+	writeLeaves(t, w, j.Config{}, `{"a": "x", "b": [1, 2]}`, `{"a": "y"}`)
+
+	groups, tbl := readParquet(t, filepath.Join(dir, "nodes.parquet"))
+
+	// a row group per WriteLeaves call
+	assert.Equal(t, 2, groups)
+	assert.Equal(t, int64(8), tbl.NumRows())
+
+	assert.Equal(t, arrow.STRING, tbl.Schema().Field(0).Type.ID())
+	// only the array elements and their _tree leaves have an ord
+	ord := tbl.Column(tbl.Schema().FieldIndices("ord")[0])
+	assert.Equal(t, arrow.INT64, ord.DataType().ID())
+	assert.Equal(t, 4, ord.NullN())
+}
+
+func TestParquetRowGroupSize(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewColumnarWriter(dir, schema.Parquet)
+	w.RowGroupSize = 4
+
+	writeLeaves(t, w, j.Config{}, `{"a": [1, 2, 3, 4, 5]}`)
+
+	groups, tbl := readParquet(t, filepath.Join(dir, "nodes.parquet"))
+
+	assert.Equal(t, int64(10), tbl.NumRows())
+	assert.Equal(t, 3, groups)
+}
+
+func TestParquetRelational(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewColumnarWriter(dir, schema.Parquet)
+	w.Relational = true
+
+	writeLeaves(t, w, j.Config{},
+		`{"order": "A1", "paid": true, "lines": [{"sku": "x", "qty": 1}, {"sku": "y", "qty": 2.5}]}`,
+	)
+
+	_, doc := readParquet(t, filepath.Join(dir, "doc.parquet"))
+	assert.Equal(t, int64(1), doc.NumRows())
+
+	paid, ok := column(t, doc, "paid").(*array.Boolean)
+	if assert.True(t, ok) {
+		assert.True(t, paid.Value(0))
+	}
+	order, ok := column(t, doc, "order").(*array.String)
+	if assert.True(t, ok) {
+		assert.Equal(t, "A1", order.Value(0))
+	}
+
+	_, lines := readParquet(t, filepath.Join(dir, "doc__lines.parquet"))
+	assert.Equal(t, int64(2), lines.NumRows())
+
+	qty, ok := column(t, lines, "qty").(*array.Float64)
+	if assert.True(t, ok) {
+		assert.Equal(t, []float64{1, 2.5}, qty.Float64Values())
+	}
+	ord, ok := column(t, lines, "_ord").(*array.Int64)
+	if assert.True(t, ok) {
+		assert.Equal(t, []int64{0, 1}, ord.Int64Values())
+	}
+
+	// decimals keep every digit, in a Decimal128 if they fit
+	dir = t.TempDir()
+	w = schema.NewColumnarWriter(dir, schema.Parquet)
+	w.Relational = true
+
+	writeLeaves(t, w, j.Config{UseNumber: true},
+		`{"amounts": [12345678901234567.89, 1], "big": 123456789012345678901234567890, "huge": 1234567890123456789012345678901234567890.5}`,
+	)
+
+	_, amounts := readParquet(t, filepath.Join(dir, "doc__amounts.parquet"))
+	val, ok := column(t, amounts, "val").(*array.Decimal128)
+	if assert.True(t, ok) && assert.Equal(t, 2, val.Len()) {
+		assert.Equal(t, &arrow.Decimal128Type{Precision: 19, Scale: 2}, val.DataType())
+		assert.Equal(t, "12345678901234567.89", val.Value(0).ToString(2))
+		assert.Equal(t, "1.00", val.Value(1).ToString(2))
+	}
+
+	_, doc = readParquet(t, filepath.Join(dir, "doc.parquet"))
+	big, ok := column(t, doc, "big").(*array.Decimal128)
+	if assert.True(t, ok) {
+		assert.Equal(t, "123456789012345678901234567890", big.Value(0).ToString(0))
+	}
+	huge, ok := column(t, doc, "huge").(*array.String)
+	if assert.True(t, ok) {
+		assert.Equal(t, "1234567890123456789012345678901234567890.5", huge.Value(0))
+	}
+}
+
+func TestParquetDefaultRowGroupSize(t *testing.T) {
+	for _, tc := range []struct {
+		relational bool
+		file       string
+		rows       int64
+	}{
+		{false, "nodes.parquet", 6},
+		{true, "doc__a.parquet", 3},
+	} {
+		dir := t.TempDir()
+		w := &schema.ColumnarWriter{Dir: dir, Relational: tc.relational}
+
+		writeLeaves(t, w, j.Config{}, `{"a": [1, 2, 3]}`)
+
+		groups, tbl := readParquet(t, filepath.Join(dir, tc.file))
+		assert.Equal(t, 1, groups)
+		assert.Equal(t, tc.rows, tbl.NumRows())
+	}
+}
+
+func TestArrowIPC(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewColumnarWriter(dir, schema.ArrowIPC)
+
+	writeLeaves(t, w, j.Config{}, `{"a": "x"}`, `{"a": "y"}`)
+
+	f, err := os.Open(filepath.Join(dir, "nodes.arrow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	assert.Equal(t, 2, r.NumRecords())
+
+	var rows int64
+	for i := 0; i < r.NumRecords(); i++ {
+		rec, err := r.Record(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows += rec.NumRows()
+	}
+	assert.Equal(t, int64(4), rows)
+}
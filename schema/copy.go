@@ -0,0 +1,78 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CopyEncoder formats rows for PostgreSQL's COPY text format. NUL bytes can't
+// be loaded by COPY at all, so they are dropped and counted in DroppedNULs.
+type CopyEncoder struct {
+	DroppedNULs int
+}
+
+// Row formats values as a tab separated line without the trailing newline.
+func (e *CopyEncoder) Row(values []interface{}) string {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = e.Field(v)
+	}
+
+	return strings.Join(fields, "\t")
+}
+
+// Field formats a single value, with nil as \N.
+func (e *CopyEncoder) Field(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return `\N`
+	case string:
+		return e.Escape(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case json.Number:
+		return string(v)
+	}
+
+	return e.Escape(fmt.Sprintf("%v", v))
+}
+
+// Escape backslash escapes s so it loads back unchanged, apart from any NULs.
+// Every character is replaced on its own, so escapes are never escaped again.
+func (e *CopyEncoder) Escape(s string) string {
+	if !strings.ContainsAny(s, "\\\b\f\n\r\t\v\x00") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) + 8)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		case 0:
+			e.DroppedNULs++
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/jbrough/json2Leaf/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeCopy parses a COPY text row the way PostgreSQL does, with nil for \N.
+func decodeCopy(t *testing.T, row string) []interface{} {
+	var fields []interface{}
+
+	for _, f := range strings.Split(row, "\t") {
+		if f == `\N` {
+			fields = append(fields, nil)
+			continue
+		}
+
+		var b strings.Builder
+		for i := 0; i < len(f); i++ {
+			if f[i] != '\\' {
+				b.WriteByte(f[i])
+				continue
+			}
+
+			i++
+			if i == len(f) {
+				t.Fatalf("trailing backslash in %q", row)
+			}
+			switch f[i] {
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'v':
+				b.WriteByte('\v')
+			default:
+				b.WriteByte(f[i])
+			}
+		}
+		fields = append(fields, b.String())
+	}
+
+	return fields
+}
+
+func TestCopyEscape(t *testing.T) {
+	var e schema.CopyEncoder
+
+	assert.Equal(t, `a\tb`, e.Escape("a\tb"))
+	assert.Equal(t, `a\\tb`, e.Escape(`a\tb`))
+	assert.Equal(t, `\\N`, e.Escape(`\N`))
+	assert.Equal(t, `\\.`, e.Escape(`\.`))
+	assert.Equal(t, `\b\f\v\r\n`, e.Escape("\b\f\v\r\n"))
+	assert.Equal(t, 0, e.DroppedNULs)
+
+	assert.Equal(t, "ab", e.Escape("a\x00b\x00"))
+	assert.Equal(t, 2, e.DroppedNULs)
+}
+
+func TestCopyRow(t *testing.T) {
+	var e schema.CopyEncoder
+
+	assert.Equal(t, "x\t\\N\ttrue\t3\t1.50", e.Row([]interface{}{"x", nil, true, 3, json.Number("1.50")}))
+}
+
+func TestCopyRoundTrip(t *testing.T) {
+	f := func(values []string) bool {
+		var e schema.CopyEncoder
+
+		row := make([]interface{}, len(values))
+		want := make([]interface{}, len(values))
+		nuls := 0
+		for i, v := range values {
+			row[i] = v
+			want[i] = strings.ReplaceAll(v, "\x00", "")
+			nuls += strings.Count(v, "\x00")
+		}
+
+		line := e.Row(row)
+		if len(values) == 0 {
+			return line == ""
+		}
+		if strings.ContainsAny(line, "\n\r") {
+			return false
+		}
+
+		return assert.Equal(t, want, decodeCopy(t, line)) && e.DroppedNULs == nuls
+	}
+
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCopyRoundTripSpecial(t *testing.T) {
+	// quick rarely generates the characters that need escaping
+	special := []byte("\\\b\f\n\r\t\v\x00Na.")
+
+	f := func(picks []uint8) bool {
+		var e schema.CopyEncoder
+
+		s := make([]byte, len(picks))
+		for i, p := range picks {
+			s[i] = special[int(p)%len(special)]
+		}
+
+		want := strings.ReplaceAll(string(s), "\x00", "")
+		return assert.Equal(t, []interface{}{want, nil}, decodeCopy(t, e.Row([]interface{}{string(s), nil})))
+	}
+
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
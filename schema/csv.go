@@ -0,0 +1,202 @@
+package schema
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jbrough/json2Leaf"
+)
+
+// CSVWriter writes leaves as CSV files into Dir, either as nodes.csv with the
+// same columns and values as the nodes table, or with a file per table in
+// relational mode.
+type CSVWriter struct {
+	Dir string
+	// Comma is the field delimiter, ',' unless set otherwise. Files written
+	// with '\t' get a .tsv extension.
+	Comma rune
+	// Header writes the column names as the first row of each file.
+	Header bool
+	// Relational writes a file per node name with a column per path. As with
+	// Generator, leaves are held in memory until Close.
+	Relational bool
+	mu         sync.Mutex
+	nodes      *csvFile
+	tables     *TableSet
+}
+
+func NewCSVWriter(dir string) *CSVWriter {
+	return &CSVWriter{
+		Dir:    dir,
+		Comma:  ',',
+		Header: true,
+	}
+}
+
+func (w *CSVWriter) WriteLeaves(leaves []json2Leaf.Leaf) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, leaf := range leaves {
+		if err := w.writeLeaf(leaf); err != nil {
+			return err
+		}
+	}
+
+	if w.nodes == nil {
+		return nil
+	}
+
+	return w.nodes.flush()
+}
+
+// WriteLeaf writes a single leaf.
+func (w *CSVWriter) WriteLeaf(leaf json2Leaf.Leaf) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.writeLeaf(leaf)
+}
+
+func (w *CSVWriter) writeLeaf(leaf json2Leaf.Leaf) error {
+	if w.Relational {
+		if w.tables == nil {
+			w.tables = NewTableSet()
+		}
+		w.tables.Add(leaf)
+
+		return nil
+	}
+
+	if w.nodes == nil {
+		f, err := w.create("nodes", columnNames(nodeColumns))
+		if err != nil {
+			return err
+		}
+		w.nodes = f
+	}
+
+	return w.nodes.WriteRow(nodeRow(leaf))
+}
+
+func (w *CSVWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.Relational {
+		return w.writeTables()
+	}
+
+	if w.nodes == nil {
+		return nil
+	}
+
+	return w.nodes.Close()
+}
+
+func (w *CSVWriter) writeTables() error {
+	if w.tables == nil {
+		return nil
+	}
+
+	for _, t := range w.tables.Tables() {
+		header := []string{"_id", "_parent_id", "_ord"}
+		for _, c := range t.Columns {
+			header = append(header, c.Name)
+		}
+
+		f, err := w.create(t.Name, header)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range t.Rows {
+			values := []interface{}{r.ID, nullable(r.ParentID), ordValue(r.Index, r.HasIndex)}
+			for _, c := range t.Columns {
+				v := r.Values[c.Path]
+				values = append(values, textValue(v.Value, v.DataType))
+			}
+
+			if err := f.WriteRow(values); err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *CSVWriter) create(name string, header []string) (*csvFile, error) {
+	ext := ".csv"
+	if w.Comma == '\t' {
+		ext = ".tsv"
+	}
+
+	f, err := os.Create(filepath.Join(w.Dir, cleanFileName(name)+ext))
+	if err != nil {
+		return nil, err
+	}
+
+	b := bufio.NewWriter(f)
+	cw := csv.NewWriter(b)
+	if w.Comma != 0 {
+		cw.Comma = w.Comma
+	}
+
+	r := &csvFile{cw, b, f}
+	if w.Header {
+		if err := cw.Write(header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// csvFile is a CSV writer and the file it writes to.
+type csvFile struct {
+	w *csv.Writer
+	b *bufio.Writer
+	f *os.File
+}
+
+// WriteRow writes values as they would be loaded by the SQL dialects, with
+// NULL as an empty field.
+func (f *csvFile) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v != nil {
+			record[i] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return f.w.Write(record)
+}
+
+func (f *csvFile) flush() error {
+	f.w.Flush()
+	if err := f.w.Error(); err != nil {
+		return err
+	}
+
+	return f.b.Flush()
+}
+
+func (f *csvFile) Close() error {
+	if err := f.flush(); err != nil {
+		f.f.Close()
+		return err
+	}
+
+	return f.f.Close()
+}
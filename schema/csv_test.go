@@ -0,0 +1,86 @@
+package schema_test
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/jbrough/json2Leaf/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func readCSV(t *testing.T, path string, comma rune) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = comma
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func TestCSVNodes(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewCSVWriter(dir)
+
+	writeLeaves(t, w, j.Config{}, `{"a": "x, \"y\"\nz", "n": 1e21}`)
+
+	records := readCSV(t, filepath.Join(dir, "nodes.csv"), ',')
+	assert.Equal(t, []string{"id", "parent_id", "name", "path", "data_type", "value", "ord", "location", "semantic_type"}, records[0])
+
+	var values []string
+	for _, r := range records[1:] {
+		if r[2] == "doc" {
+			values = append(values, r[5])
+		}
+	}
+	sort.Strings(values)
+
+	assert.Equal(t, []string{"1000000000000000000000", "x, \"y\"\nz"}, values)
+}
+
+func TestTSVRelational(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewCSVWriter(dir)
+	w.Comma = '\t'
+	w.Header = false
+	w.Relational = true
+
+	writeLeaves(t, w, j.Config{}, `{"order": "A1", "lines": [{"sku": "x", "qty": 1}, {"sku": "y\tz", "qty": 2.5}]}`)
+
+	doc := readCSV(t, filepath.Join(dir, "doc.tsv"), '\t')
+	if assert.Len(t, doc, 1) {
+		assert.Equal(t, []string{"", "", "A1"}, doc[0][1:])
+	}
+
+	lines := readCSV(t, filepath.Join(dir, "doc__lines.tsv"), '\t')
+	if assert.Len(t, lines, 2) {
+		assert.Equal(t, doc[0][0], lines[0][1])
+		assert.Equal(t, []string{"0", "1", "x"}, lines[0][2:])
+		assert.Equal(t, []string{"1", "2.5", "y\tz"}, lines[1][2:])
+	}
+}
+
+func TestCSVRelationalIDKey(t *testing.T) {
+	dir := t.TempDir()
+	w := schema.NewCSVWriter(dir)
+	w.Relational = true
+
+	writeLeaves(t, w, j.Config{}, `{"_id": "abc", "x": 1}`)
+
+	doc := readCSV(t, filepath.Join(dir, "doc.csv"), ',')
+	if assert.Len(t, doc, 2) {
+		assert.Equal(t, []string{"_id", "_parent_id", "_ord", "_id_1", "x"}, doc[0])
+		assert.Equal(t, []string{"abc", "1"}, doc[1][3:])
+	}
+}
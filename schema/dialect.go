@@ -0,0 +1,478 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts the SQL written by Generator to a database. Column types are
+// passed as the PostgreSQL names Generator uses, e.g. BIGINT, and row values
+// are nil, string, bool, int or json.Number.
+type Dialect interface {
+	Name() string
+	Type(sqlType string) string
+	Quote(ident string) string
+	Cast(expr, sqlType string) string
+	DropTable(table string) string
+	// Unique is a table constraint keeping columns unique together.
+	Unique(columns []string) string
+	PrimaryKey(columns []string) string
+	// Index indexes columns of table, either with a table constraint or a
+	// statement run after the table is created. Statements can be run again.
+	Index(name, table string, columns []string) (constraint, statement string)
+	// Merge inserts the rows of from into table, updating the other columns
+	// of rows whose key columns are already there. key must be Unique.
+	Merge(table, from string, columns, key []string) string
+	// Rows starts a block of rows for table. Rows are written to w until the
+	// RowWriter is closed.
+	Rows(w io.Writer, table string, columns []string) (RowWriter, error)
+	// Placeholder is the nth parameter of a statement run through
+	// database/sql, counting from 1.
+	Placeholder(n int) string
+}
+
+type RowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// Dialects are those selectable by name with LookupDialect.
+var Dialects = []Dialect{
+	PostgreSQL{},
+	SQLite{},
+	MySQL{},
+	DuckDB{},
+}
+
+func LookupDialect(name string) (Dialect, error) {
+	var names []string
+	for _, d := range Dialects {
+		if d.Name() == name {
+			return d, nil
+		}
+		names = append(names, d.Name())
+	}
+
+	return nil, fmt.Errorf("unknown dialect %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// PostgreSQL loads rows with COPY ... FROM stdin, so scripts must be run with
+// psql.
+type PostgreSQL struct{}
+
+func (PostgreSQL) Name() string {
+	return "postgres"
+}
+
+func (PostgreSQL) Type(sqlType string) string {
+	return sqlType
+}
+
+func (PostgreSQL) Quote(ident string) string {
+	return quoteIdent(ident)
+}
+
+func (PostgreSQL) Cast(expr, sqlType string) string {
+	return fmt.Sprintf("%s::%s", expr, sqlType)
+}
+
+func (PostgreSQL) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE;\n", table)
+}
+
+func (PostgreSQL) Unique(columns []string) string {
+	return unique(columns)
+}
+
+func (PostgreSQL) PrimaryKey(columns []string) string {
+	return primaryKey(columns)
+}
+
+func (PostgreSQL) Index(name, table string, columns []string) (string, string) {
+	return "", createIndex(name, table, columns)
+}
+
+func (PostgreSQL) Merge(table, from string, columns, key []string) string {
+	return onConflictMerge(table, from, columns, key)
+}
+
+func (PostgreSQL) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (PostgreSQL) Rows(w io.Writer, table string, columns []string) (RowWriter, error) {
+	_, err := fmt.Fprintf(w, "COPY %s (%s) FROM stdin;\n", table, strings.Join(columns, ", "))
+	return &copyRows{w: w}, err
+}
+
+type copyRows struct {
+	w   io.Writer
+	enc CopyEncoder
+}
+
+func (r *copyRows) WriteRow(values []interface{}) error {
+	_, err := io.WriteString(r.w, r.enc.Row(values)+"\n")
+	return err
+}
+
+func (r *copyRows) Close() error {
+	_, err := io.WriteString(r.w, "\\.\n")
+	return err
+}
+
+func (r *copyRows) DroppedNULs() int {
+	return r.enc.DroppedNULs
+}
+
+const defaultBatchSize = 500
+
+// SQLite loads rows with multi-row INSERTs of BatchSize rows, each block of
+// rows in a transaction.
+type SQLite struct {
+	BatchSize int
+}
+
+func (SQLite) Name() string {
+	return "sqlite"
+}
+
+func (SQLite) Type(sqlType string) string {
+	switch sqlType {
+	case "VARCHAR", "TIMESTAMPTZ", "DATE", "UUID":
+		return "TEXT"
+	case "BIGINT":
+		return "INTEGER"
+	}
+
+	return sqlType
+}
+
+func (SQLite) Quote(ident string) string {
+	return quoteIdent(ident)
+}
+
+func (d SQLite) Cast(expr, sqlType string) string {
+	// BOOLEAN has NUMERIC affinity, which casts 'true' to 0
+	if sqlType == "BOOLEAN" {
+		return fmt.Sprintf("(LOWER(%s) = 'true')", expr)
+	}
+
+	return fmt.Sprintf("CAST(%s AS %s)", expr, d.Type(sqlType))
+}
+
+func (SQLite) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s;\n", table)
+}
+
+func (SQLite) Unique(columns []string) string {
+	return unique(columns)
+}
+
+func (SQLite) PrimaryKey(columns []string) string {
+	return primaryKey(columns)
+}
+
+func (SQLite) Index(name, table string, columns []string) (string, string) {
+	return "", createIndex(name, table, columns)
+}
+
+func (SQLite) Merge(table, from string, columns, key []string) string {
+	return onConflictMerge(table, from, columns, key)
+}
+
+func (SQLite) Placeholder(n int) string {
+	return "?"
+}
+
+func (d SQLite) Rows(w io.Writer, table string, columns []string) (RowWriter, error) {
+	return newInsertRows(w, table, columns, d.BatchSize, func(v interface{}) string {
+		return literal(v, quoteString, "1", "0")
+	})
+}
+
+// MySQL loads rows with multi-row INSERTs of BatchSize rows, each block of
+// rows in a transaction.
+type MySQL struct {
+	BatchSize int
+}
+
+func (MySQL) Name() string {
+	return "mysql"
+}
+
+func (MySQL) Type(sqlType string) string {
+	switch sqlType {
+	// the longest key InnoDB can index with utf8mb4
+	case "VARCHAR":
+		return "VARCHAR(768)"
+	case "TEXT":
+		return "LONGTEXT"
+	case "NUMERIC":
+		return "DECIMAL(65,30)"
+	case "TIMESTAMPTZ":
+		return "DATETIME(6)"
+	case "UUID":
+		return "CHAR(36)"
+	}
+
+	return sqlType
+}
+
+func (MySQL) Quote(ident string) string {
+	return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+}
+
+func (d MySQL) Cast(expr, sqlType string) string {
+	switch sqlType {
+	case "BIGINT", "INTEGER":
+		return fmt.Sprintf("CAST(%s AS SIGNED)", expr)
+	case "BOOLEAN":
+		return fmt.Sprintf("(LOWER(%s) = 'true')", expr)
+	case "TEXT", "VARCHAR":
+		return fmt.Sprintf("CAST(%s AS CHAR)", expr)
+	}
+
+	return fmt.Sprintf("CAST(%s AS %s)", expr, d.Type(sqlType))
+}
+
+func (MySQL) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s;\n", table)
+}
+
+// Unique and PrimaryKey index a prefix of each column, as InnoDB keys are
+// limited to 3072 bytes in all, so values that only differ after 255
+// characters collide.
+func (MySQL) Unique(columns []string) string {
+	return unique(prefixes(columns))
+}
+
+func (MySQL) PrimaryKey(columns []string) string {
+	return primaryKey(prefixes(columns))
+}
+
+func prefixes(columns []string) []string {
+	r := make([]string, len(columns))
+	for i, c := range columns {
+		r[i] = c + "(255)"
+	}
+
+	return r
+}
+
+// Index is a table constraint, as MySQL can't create an index if it doesn't
+// already exist. Like keys it indexes prefixes, which TEXT columns need.
+func (MySQL) Index(name, table string, columns []string) (string, string) {
+	return fmt.Sprintf("INDEX %s (%s)", name, strings.Join(prefixes(columns), ", ")), ""
+}
+
+func (MySQL) Merge(table, from string, columns, key []string) string {
+	var set []string
+	for _, c := range nonKey(columns, key) {
+		set = append(set, fmt.Sprintf("%s = VALUES(%s)", c, c))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s)\nSELECT %s FROM %s\nON DUPLICATE KEY UPDATE %s;\n",
+		table, strings.Join(columns, ", "), strings.Join(columns, ", "), from, strings.Join(set, ", "))
+}
+
+func (MySQL) Placeholder(n int) string {
+	return "?"
+}
+
+func (d MySQL) Rows(w io.Writer, table string, columns []string) (RowWriter, error) {
+	return newInsertRows(w, table, columns, d.BatchSize, func(v interface{}) string {
+		return literal(v, quoteMySQLString, "TRUE", "FALSE")
+	})
+}
+
+// DuckDB loads rows with multi-row INSERTs of BatchSize rows, each block of
+// rows in a transaction.
+type DuckDB struct {
+	BatchSize int
+}
+
+func (DuckDB) Name() string {
+	return "duckdb"
+}
+
+func (DuckDB) Type(sqlType string) string {
+	// DuckDB's NUMERIC defaults to DECIMAL(18,3), which truncates, and a
+	// DOUBLE rounds large decimals, so this is the widest it has with room
+	// for fractions. Table columns are sized to their values instead.
+	if sqlType == "NUMERIC" {
+		return "DECIMAL(38,10)"
+	}
+
+	return sqlType
+}
+
+func (DuckDB) Quote(ident string) string {
+	return quoteIdent(ident)
+}
+
+func (d DuckDB) Cast(expr, sqlType string) string {
+	return fmt.Sprintf("TRY_CAST(%s AS %s)", expr, d.Type(sqlType))
+}
+
+func (DuckDB) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE;\n", table)
+}
+
+func (DuckDB) Unique(columns []string) string {
+	return unique(columns)
+}
+
+func (DuckDB) PrimaryKey(columns []string) string {
+	return primaryKey(columns)
+}
+
+func (DuckDB) Index(name, table string, columns []string) (string, string) {
+	return "", createIndex(name, table, columns)
+}
+
+func (DuckDB) Merge(table, from string, columns, key []string) string {
+	return onConflictMerge(table, from, columns, key)
+}
+
+func (DuckDB) Placeholder(n int) string {
+	return "?"
+}
+
+func (d DuckDB) Rows(w io.Writer, table string, columns []string) (RowWriter, error) {
+	return newInsertRows(w, table, columns, d.BatchSize, func(v interface{}) string {
+		return literal(v, quoteString, "TRUE", "FALSE")
+	})
+}
+
+func unique(columns []string) string {
+	return fmt.Sprintf("UNIQUE (%s)", strings.Join(columns, ", "))
+}
+
+func primaryKey(columns []string) string {
+	return fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(columns, ", "))
+}
+
+func createIndex(name, table string, columns []string) string {
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s);\n", name, table, strings.Join(columns, ", "))
+}
+
+// onConflictMerge merges with INSERT ... ON CONFLICT, which SQLite only parses
+// after a SELECT with a WHERE clause.
+func onConflictMerge(table, from string, columns, key []string) string {
+	var set []string
+	for _, c := range nonKey(columns, key) {
+		set = append(set, fmt.Sprintf("%s = excluded.%s", c, c))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s)\nSELECT %s FROM %s WHERE true\nON CONFLICT (%s) DO UPDATE SET %s;\n",
+		table, strings.Join(columns, ", "), strings.Join(columns, ", "), from, strings.Join(key, ", "), strings.Join(set, ", "))
+}
+
+func nonKey(columns, key []string) (r []string) {
+	keys := make(map[string]bool)
+	for _, k := range key {
+		keys[k] = true
+	}
+
+	for _, c := range columns {
+		if !keys[c] {
+			r = append(r, c)
+		}
+	}
+
+	return
+}
+
+type insertRows struct {
+	w       io.Writer
+	insert  string
+	batch   int
+	n       int
+	literal func(v interface{}) string
+}
+
+func newInsertRows(w io.Writer, table string, columns []string, batch int, literal func(v interface{}) string) (*insertRows, error) {
+	if batch <= 0 {
+		batch = defaultBatchSize
+	}
+
+	_, err := io.WriteString(w, "BEGIN;\n")
+
+	return &insertRows{
+		w:       w,
+		insert:  fmt.Sprintf("INSERT INTO %s (%s) VALUES\n", table, strings.Join(columns, ", ")),
+		batch:   batch,
+		literal: literal,
+	}, err
+}
+
+func (r *insertRows) WriteRow(values []interface{}) error {
+	sep := ",\n"
+	if r.n%r.batch == 0 {
+		sep = r.insert
+		if r.n > 0 {
+			sep = ";\n" + sep
+		}
+	}
+	r.n++
+
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = r.literal(v)
+	}
+
+	_, err := io.WriteString(r.w, sep+"("+strings.Join(literals, ", ")+")")
+	return err
+}
+
+func (r *insertRows) Close() error {
+	end := "COMMIT;\n"
+	if r.n > 0 {
+		end = ";\n" + end
+	}
+
+	_, err := io.WriteString(r.w, end)
+	return err
+}
+
+func literal(v interface{}, quote func(s string) string, t, f string) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return quote(v)
+	case bool:
+		if v {
+			return t
+		}
+		return f
+	case int:
+		return strconv.Itoa(v)
+	case json.Number:
+		return string(v)
+	}
+
+	return quote(fmt.Sprintf("%v", v))
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteString quotes s as a standard SQL string literal. NUL can't be
+// represented and is dropped, as it is for COPY.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, "\x00", "")
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteMySQLString also escapes backslashes, which MySQL treats as an escape
+// character by default.
+func quoteMySQLString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\x00", `\0`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,448 @@
+package schema_test
+
+import (
+	"bufio"
+	"database/sql"
+	"strings"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/jbrough/json2Leaf/schema"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func openSQLite(t *testing.T, script string) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// an in-memory database is private to its connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(script); err != nil {
+		t.Fatalf("%v\n%s", err, script)
+	}
+
+	return db
+}
+
+// count runs a query for a single number.
+func count(t *testing.T, db *sql.DB, query string) (n int) {
+	if err := db.QueryRow(query).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+const dialectDoc = `{
+	"name": "it's a \"test\"\twith\nlines \\ and more",
+	"count": 3,
+	"ok": true,
+	"nothing": null,
+	"items": [{"sku": "x", "price": 1.5}, {"sku": "y", "price": 2}, {"sku": "z"}]
+}`
+
+func TestSQLiteNodes(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{BatchSize: 2}
+	g.TypedViews = true
+
+	c := j.Config{KeepEmpty: true}
+
+	out := generate(t, g, c, dialectDoc, dialectDoc)
+	db := openSQLite(t, out)
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM nodes WHERE name <> '_tree'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2*9, n)
+
+	var name string
+	if err := db.QueryRow(`SELECT value FROM nodes WHERE name = 'doc' AND path = 'name'`).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "it's a \"test\"\twith\nlines \\ and more", name)
+
+	var nothing sql.NullString
+	if err := db.QueryRow(`SELECT value FROM nodes WHERE path = 'nothing'`).Scan(&nothing); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, nothing.Valid)
+
+	var ord int
+	if err := db.QueryRow(`SELECT ord FROM nodes WHERE path = 'sku' AND value = 'z'`).Scan(&ord); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, ord)
+
+	var sum float64
+	if err := db.QueryRow(`SELECT SUM(value_numeric) FROM nodes_typed WHERE path = 'price'`).Scan(&sum); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 7.0, sum)
+
+	var ok bool
+	if err := db.QueryRow(`SELECT value_boolean FROM nodes_typed WHERE path = 'ok'`).Scan(&ok); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, ok)
+}
+
+func TestSQLiteRelational(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Relational = true
+
+	out := generate(t, g, j.NewConfig(), dialectDoc)
+	db := openSQLite(t, out)
+
+	rows, err := db.Query(`
+		SELECT l.sku, l.price, d.count, d.ok
+		FROM "doc__items" l JOIN "doc" d ON l._parent_id = d._id
+		ORDER BY l._ord`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var skus []string
+	var total float64
+	for rows.Next() {
+		var sku string
+		var price sql.NullFloat64
+		var count int
+		var ok bool
+		if err := rows.Scan(&sku, &price, &count, &ok); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 3, count)
+		assert.True(t, ok)
+
+		skus = append(skus, sku)
+		total += price.Float64
+	}
+
+	assert.Equal(t, []string{"x", "y", "z"}, skus)
+	assert.Equal(t, 3.5, total)
+}
+
+func TestRelationalIDKey(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Relational = true
+
+	out := generate(t, g, j.NewConfig(), `{"_id": "abc", "_ord": 5, "x": 1}`)
+	db := openSQLite(t, out)
+
+	var id, docID string
+	var ord sql.NullInt64
+	var docOrd int
+	if err := db.QueryRow(`SELECT _id, _id_1, _ord, _ord_1 FROM "doc"`).Scan(&id, &docID, &ord, &docOrd); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotEqual(t, "abc", id)
+	assert.Equal(t, "abc", docID)
+	assert.False(t, ord.Valid)
+	assert.Equal(t, 5, docOrd)
+}
+
+func TestDialects(t *testing.T) {
+	for _, name := range []string{"postgres", "sqlite", "mysql", "duckdb"} {
+		d, err := schema.LookupDialect(name)
+		if assert.NoError(t, err) {
+			assert.Equal(t, name, d.Name())
+		}
+	}
+
+	_, err := schema.LookupDialect("oracle")
+	assert.Error(t, err)
+
+	for _, d := range []schema.Dialect{schema.MySQL{}, schema.DuckDB{}} {
+		g := schema.NewGenerator()
+		g.Dialect = d
+
+		out := generate(t, g, j.NewConfig(), dialectDoc)
+
+		assert.True(t, strings.HasPrefix(out, "DROP TABLE IF EXISTS nodes"))
+		assert.Contains(t, out, "BEGIN;\nINSERT INTO nodes (id, parent_id, name, path, data_type, value, ord, location, semantic_type, ingest_id, source, content_hash) VALUES\n")
+		assert.True(t, strings.HasSuffix(out, ");\nCOMMIT;\n"))
+
+		switch d.(type) {
+		case schema.MySQL:
+			assert.Contains(t, out, `'it''s a "test"	with
+lines \\ and more'`)
+			assert.Contains(t, out, "id VARCHAR(768)")
+		case schema.DuckDB:
+			assert.Contains(t, out, `'it''s a "test"	with
+lines \ and more'`)
+		}
+	}
+}
+
+func TestDuckDBDecimal(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.DuckDB{}
+	g.Relational = true
+
+	out := generate(t, g, j.Config{UseNumber: true}, `{"amount": 12345678901234567.89, "big": 123456789012345678901234567890, "huge": 1234567890123456789012345678901234567890.5}`)
+	assert.Contains(t, out, `"amount" DECIMAL(19,2),`)
+	assert.Contains(t, out, `"big" DECIMAL(30,0),`)
+	assert.Contains(t, out, `"huge" TEXT,`)
+	assert.Contains(t, out, ", NULL, NULL, 12345678901234567.89, 123456789012345678901234567890, '1234567890123456789012345678901234567890.5', ")
+}
+
+func TestSQLiteAppend(t *testing.T) {
+	c := j.Config{DeterministicIDs: true}
+
+	run := func(docs ...string) (string, *schema.Generator) {
+		g := schema.NewGenerator()
+		g.Dialect = schema.SQLite{}
+		g.Append = true
+		g.TypedViews = true
+
+		return generate(t, g, c, docs...), g
+	}
+
+	first, _ := run(dialectDoc)
+	assert.NotContains(t, first, "DROP TABLE IF EXISTS nodes;")
+	assert.Contains(t, first, "CREATE TABLE IF NOT EXISTS nodes (")
+	db := openSQLite(t, first)
+
+	leaves := count(t, db, `SELECT COUNT(*) FROM nodes`)
+
+	// loading the same document again replaces its rows
+	again, g := run(dialectDoc)
+	if _, err := db.Exec(again); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, leaves, count(t, db, `SELECT COUNT(*) FROM nodes`))
+	assert.Equal(t, leaves, count(t, db, `SELECT COUNT(*) FROM nodes WHERE ingest_id = '`+g.Ingests()[0].ID+`'`))
+	assert.Equal(t, 2, count(t, db, `SELECT COUNT(*) FROM ingests`))
+	assert.Equal(t, 0, count(t, db, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'nodes_staging'`))
+
+	// ids only depend on the document's name and locations, so a new
+	// version updates the values it shares with the last
+	next, _ := run(`{"name": "next", "more": true}`)
+	if _, err := db.Exec(next); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, leaves+1, count(t, db, `SELECT COUNT(*) FROM nodes`))
+	assert.Equal(t, 1, count(t, db, `SELECT COUNT(*) FROM nodes_typed WHERE value = 'next'`))
+	assert.Equal(t, 1, count(t, db, `SELECT COUNT(*) FROM nodes WHERE path = 'more' AND value = 'true'`))
+}
+
+func TestMerge(t *testing.T) {
+	columns := []string{"id", "name", "value"}
+	key := []string{"id", "name"}
+
+	assert.Equal(t, "INSERT INTO t (id, name, value)\nSELECT id, name, value FROM s WHERE true\nON CONFLICT (id, name) DO UPDATE SET value = excluded.value;\n",
+		schema.PostgreSQL{}.Merge("t", "s", columns, key))
+	assert.Equal(t, "INSERT INTO t (id, name, value)\nSELECT id, name, value FROM s\nON DUPLICATE KEY UPDATE value = VALUES(value);\n",
+		schema.MySQL{}.Merge("t", "s", columns, key))
+	assert.Equal(t, "UNIQUE (id(255), name(255))", schema.MySQL{}.Unique(key))
+}
+
+func TestSQLiteTreeViews(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Keys = true
+	g.TreeViews = true
+	g.TypedViews = true
+
+	out := generate(t, g, j.NewConfig(), dialectDoc)
+	assert.Contains(t, out, "PRIMARY KEY (id, name, path)\n);\nCREATE INDEX IF NOT EXISTS nodes_parent_id ON nodes (parent_id);\n")
+	assert.NotContains(t, out, "FUNCTION")
+	db := openSQLite(t, out)
+
+	var id, path string
+	var depth int
+	if err := db.QueryRow(`SELECT id, full_path, depth FROM node_tree WHERE full_path LIKE '%[2]'`).Scan(&id, &path, &depth); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "doc/items[2]", path)
+	assert.Equal(t, 1, depth)
+
+	var root string
+	var distance int
+	if err := db.QueryRow(`SELECT ancestor_id, distance FROM node_ancestors WHERE id = ?`, id).Scan(&root, &distance); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, distance)
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM node_ancestors WHERE ancestor_id = ?`, root).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, n)
+
+	// the primary key holds
+	_, err := db.Exec(`INSERT INTO nodes (id, name, path, data_type) SELECT id, name, path, data_type FROM nodes LIMIT 1`)
+	assert.Error(t, err)
+}
+
+func TestSQLiteFoldedKeys(t *testing.T) {
+	// fooBar and foo_bar fold onto the same path
+	const doc = `{"fooBar": 1, "foo_bar": 2}`
+
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Keys = true
+	db := openSQLite(t, generate(t, g, j.NewConfig(), doc))
+	assert.Equal(t, 2, count(t, db, `SELECT COUNT(*) FROM nodes WHERE path LIKE 'foo_bar%'`))
+
+	appended := func() string {
+		g := schema.NewGenerator()
+		g.Dialect = schema.SQLite{}
+		g.Append = true
+
+		return generate(t, g, j.Config{DeterministicIDs: true}, doc)
+	}
+	db = openSQLite(t, appended())
+	if _, err := db.Exec(appended()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, count(t, db, `SELECT COUNT(*) FROM nodes WHERE path LIKE 'foo_bar%'`))
+
+	g = schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Relational = true
+	db = openSQLite(t, generate(t, g, j.NewConfig(), doc))
+	var fooBar, fooBar2 int
+	if err := db.QueryRow(`SELECT foo_bar, foo_bar_2 FROM "doc"`).Scan(&fooBar, &fooBar2); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{1, 2}, []int{fooBar, fooBar2})
+}
+
+func TestTreeViews(t *testing.T) {
+	for _, d := range []schema.Dialect{schema.PostgreSQL{}, schema.DuckDB{}} {
+		g := schema.NewGenerator()
+		g.Dialect = d
+		g.TreeViews = true
+
+		out := generate(t, g, j.NewConfig(), `{}`)
+		assert.True(t, strings.HasPrefix(out, "DROP VIEW IF EXISTS node_ancestors;\nDROP VIEW IF EXISTS node_tree;\n"))
+		assert.Contains(t, out, "CREATE VIEW node_tree AS\nWITH RECURSIVE")
+
+		switch d.(type) {
+		case schema.PostgreSQL:
+			assert.Contains(t, out, "CREATE OR REPLACE FUNCTION subtree(root VARCHAR)")
+			assert.Contains(t, out, "n.id = $1")
+		case schema.DuckDB:
+			assert.Contains(t, out, "CREATE OR REPLACE MACRO ancestors(node) AS TABLE")
+			assert.Contains(t, out, "n.id = node")
+		}
+	}
+
+	g := schema.NewGenerator()
+	g.Dialect = schema.MySQL{}
+	g.Keys = true
+	g.TreeViews = true
+	g.Writer = bufio.NewWriter(&strings.Builder{})
+	assert.Error(t, g.WriteInitScript())
+
+	g.TreeViews = false
+	var b strings.Builder
+	g.Writer = bufio.NewWriter(&b)
+	if assert.NoError(t, g.WriteInitScript()) {
+		g.Writer.Flush()
+		assert.Contains(t, b.String(), "    PRIMARY KEY (id(255), name(255), path(255)),\n    INDEX nodes_parent_id (parent_id(255)),")
+	}
+}
+
+func TestSQLiteTreePath(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.TreePath = true
+
+	out := generate(t, g, j.NewConfig(), dialectDoc)
+	assert.Contains(t, out, "    tree_path TEXT\n);\nCREATE INDEX IF NOT EXISTS nodes_tree_path ON nodes (tree_path);\n")
+	db := openSQLite(t, out)
+
+	var root string
+	if err := db.QueryRow(`SELECT tree_path FROM nodes WHERE name = '_tree' AND parent_id IS NULL`).Scan(&root); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, root, "-")
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM nodes WHERE tree_path LIKE ? AND path = 'sku'`, root+".%").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, n)
+}
+
+func TestLtree(t *testing.T) {
+	g := schema.NewGenerator()
+	g.TreePath = true
+
+	out := generate(t, g, j.NewConfig(), `{"a": [{"b": 1}]}`)
+	assert.True(t, strings.HasPrefix(out, "DROP TABLE IF EXISTS nodes CASCADE;\n\nCREATE EXTENSION IF NOT EXISTS ltree;\nCREATE TABLE nodes ("))
+	assert.Contains(t, out, "    tree_path LTREE\n);\nCREATE INDEX IF NOT EXISTS nodes_tree_path ON nodes USING GIST (tree_path);\n")
+
+	lines := strings.Split(out, "\n")
+	for _, l := range lines {
+		if strings.Contains(l, "\tdoc__a\tb\t") {
+			path := l[strings.LastIndex(l, "\t")+1:]
+			assert.Regexp(t, `^[0-9a-f_]{36}\.[0-9a-f_]{36}$`, path)
+		}
+	}
+
+	g = schema.NewGenerator()
+	g.TreePath = true
+	g.TextTreePath = true
+	out = generate(t, g, j.NewConfig(), `{}`)
+	assert.NotContains(t, out, "ltree")
+	assert.Contains(t, out, "    tree_path TEXT\n);\nCREATE INDEX IF NOT EXISTS nodes_tree_path ON nodes (tree_path text_pattern_ops);\n")
+}
+
+func TestSQLiteClosure(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Closure = true
+	g.TreeViews = true
+
+	out := generate(t, g, j.NewConfig(), dialectDoc)
+	assert.Contains(t, out, "    depth INTEGER NOT NULL,\n    PRIMARY KEY (ancestor_id, descendant_id)\n);\nCREATE INDEX IF NOT EXISTS node_closure_descendant_id ON node_closure (descendant_id);\n")
+	db := openSQLite(t, out)
+
+	// every leaf under the root, with a plain join
+	assert.Equal(t, 3, count(t, db, `SELECT COUNT(*) FROM nodes r
+		JOIN node_closure c ON c.ancestor_id = r.id
+		JOIN nodes n ON n.id = c.descendant_id
+		WHERE r.name = '_tree' AND r.parent_id IS NULL AND n.path = 'sku'`))
+	assert.Equal(t, count(t, db, `SELECT COUNT(*) FROM nodes WHERE name = '_tree'`), count(t, db, `SELECT COUNT(*) FROM node_closure WHERE depth = 0`))
+	assert.Equal(t, 0, count(t, db, `SELECT COUNT(*) FROM node_ancestors a
+		LEFT JOIN node_closure c ON c.ancestor_id = a.ancestor_id AND c.descendant_id = a.id AND c.depth = a.distance
+		WHERE c.depth IS NULL`))
+	assert.Equal(t, count(t, db, `SELECT COUNT(*) FROM node_ancestors`), count(t, db, `SELECT COUNT(*) FROM node_closure WHERE depth > 0`))
+}
+
+func TestSQLiteClosureAppend(t *testing.T) {
+	c := j.Config{DeterministicIDs: true}
+
+	run := func() string {
+		g := schema.NewGenerator()
+		g.Dialect = schema.SQLite{}
+		g.Append = true
+		g.Closure = true
+
+		return generate(t, g, c, dialectDoc)
+	}
+
+	db := openSQLite(t, run())
+
+	rows := count(t, db, `SELECT COUNT(*) FROM node_closure`)
+
+	if _, err := db.Exec(run()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rows, count(t, db, `SELECT COUNT(*) FROM node_closure`))
+	assert.Equal(t, 0, count(t, db, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'node_closure_staging'`))
+}
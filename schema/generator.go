@@ -3,9 +3,13 @@ package schema
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -14,12 +18,12 @@ import (
 
 // sometimes we may want to srongly name files when creating them by appending
 // a hash of their contents that provides for multiple versions of the same
-// document. But these hashes aren't useful in the node name.
-// TODO: this hash is not useful for the sql import but we should add a version
-// id to all rows when inserting new records from file. A time sortable ksuid?
+// document. But these hashes aren't useful in the node name, so they're moved
+// to the content_hash column, next to the ingest the row was loaded in.
 var (
 	mdHashDoubleUnderscore = regexp.MustCompile(`__[a-f0-9]{32}__`)
 	mdHashEnd              = regexp.MustCompile(`__[a-f0-9]{32}$`)
+	mdHash                 = regexp.MustCompile(`__([a-f0-9]{32})(?:__|$)`)
 )
 
 func cleanName(name string) string {
@@ -27,119 +31,621 @@ func cleanName(name string) string {
 	return mdHashEnd.ReplaceAllString(name, "")
 }
 
+// contentHash is the hash that cleanName removes from name, if any.
+func contentHash(name string) string {
+	if m := mdHash.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
+
+// leafHash is the content hash in the name of the leaf's node.
+func leafHash(leaf json2Leaf.Leaf) string {
+	if leaf.Name == "_tree" {
+		return contentHash(fmt.Sprintf("%v", leaf.Value))
+	}
+
+	return contentHash(leaf.Name)
+}
+
 type Generator struct {
-	File    *os.File
-	Writer  *bufio.Writer
+	File   *os.File
+	Writer *bufio.Writer
+	// Dialect is the database the script is written for, PostgreSQL unless
+	// set otherwise.
+	Dialect Dialect
+	// TypedViews makes WriteInitScript also create a nodes_typed view that
+	// casts value to a column per SQL type, based on each row's semantic_type
+	// or data_type.
+	TypedViews bool
+	// Relational writes a typed table per node name instead of the nodes
+	// table. Column types depend on every leaf, so leaves are held in memory
+	// and the tables are only written on Close.
+	Relational bool
+	// BinaryDir makes PostgreSQL rows load from a binary COPY file per table,
+	// written to BinaryDir, instead of COPY text in the script. COPY reads
+	// these files on the server, which must see them at the same path.
+	BinaryDir string
+	// Append adds to the nodes table instead of replacing it. Rows are loaded
+	// into a staging table, then merged into nodes, replacing any row with
+	// the same id, name and path. Ids are only the same across runs with
+	// json2Leaf.Config.DeterministicIDs, and nodes must have been created in
+	// append mode, which makes those columns unique.
+	Append bool
+	// Keys makes id, name and path the primary key of nodes, and indexes
+	// parent_id, name and path.
+	Keys bool
+	// TreeViews creates views for traversing the tree with recursive queries:
+	// node_tree with every node's depth and full path, and node_ancestors
+	// with every node's ancestors. PostgreSQL and DuckDB also get subtree(id)
+	// and ancestors(id) functions. MySQL isn't supported.
+	TreeViews bool
+	// TreePath adds a tree_path column with the ids of each row's node and
+	// its ancestors, root first, so a subtree can be selected with a single
+	// indexed predicate. On PostgreSQL it is an ltree with a GiST index,
+	// unless TextTreePath is set for servers without the ltree extension.
+	// Elsewhere it is indexed text, for prefix matches with LIKE.
+	TreePath     bool
+	TextTreePath bool
+	// Closure adds a node_closure table with a row for every node and each of
+	// its ancestors, with how far above it the ancestor is, so a subtree is a
+	// plain join instead of a recursive query. Its rows are written after the
+	// nodes or tables rows.
+	Closure bool
 	mu      sync.Mutex
 	types   map[string]string
-	started bool
+	rows    RowWriter
+	tables  *TableSet
+	nuls    int
+	ingests []*Ingest
+	// ingests of relational rows by id
+	rowIngests map[string]*Ingest
+	// closure rows, their spool file, and the nodes they've been written for
+	closure      RowWriter
+	closureFile  *os.File
+	closureSpool *bufio.Writer
+	closed       map[string]bool
+	parents      map[string]string
+	pending      []string
+	// load starts rows that aren't written to the script, for SQLLoader
+	load func(table string, columns []string) (RowWriter, error)
 }
 
 func NewGenerator() *Generator {
 	return &Generator{
+		Dialect: PostgreSQL{},
 		types: map[string]string{
 			"string":  "TEXT",
 			"float64": "NUMERIC",
 			"bool":    "BOOLEAN",
+			"integer": "BIGINT",
+			"decimal": "NUMERIC",
+			// semantic types of string values
+			"timestamptz": "TIMESTAMPTZ",
+			"date":        "DATE",
+			"uuid":        "UUID",
+			"boolean":     "BOOLEAN",
+			"email":       "TEXT",
 		},
 	}
 }
 
+// columnDef is a column of a generated table. name is used as is, so names
+// derived from documents must already be quoted.
+type columnDef struct {
+	name       string
+	sqlType    string
+	constraint string
+}
+
+var nodeColumns = []columnDef{
+	{"id", "VARCHAR", ""},
+	{"parent_id", "VARCHAR", ""},
+	{"name", "VARCHAR", "NOT NULL"},
+	{"path", "VARCHAR", "NOT NULL"},
+	{"data_type", "VARCHAR", "NOT NULL"},
+	{"value", "TEXT", ""},
+	{"ord", "INTEGER", ""},
+	{"location", "TEXT", ""},
+	{"semantic_type", "VARCHAR", ""},
+}
+
+// nodesTableColumns are those of the nodes table written by Generator, before
+// any tree_path.
+var nodesTableColumns = append(append([]columnDef{}, nodeColumns...), ingestColumns...)
+
+func (g *Generator) nodesColumns() []columnDef {
+	if !g.TreePath {
+		return nodesTableColumns
+	}
+
+	sqlType := "TEXT"
+	if g.ltree() {
+		sqlType = "LTREE"
+	}
+
+	return append(append([]columnDef{}, nodesTableColumns...), columnDef{"tree_path", sqlType, ""})
+}
+
+// nodesKey identifies a row of the nodes table, as a node has a row per path,
+// numbered by the Mapper where keys fold onto the same one, and one for its
+// _tree leaf.
+var nodesKey = []string{"id", "name", "path"}
+
+// nodesStaging is loaded in append mode, then merged into nodes.
+const nodesStaging = "nodes_staging"
+
+func columnNames(columns []columnDef) (r []string) {
+	for _, c := range columns {
+		r = append(r, c.name)
+	}
+
+	return
+}
+
+func createTable(d Dialect, table string, columns []columnDef, constraints ...string) string {
+	var defs []string
+	for _, c := range columns {
+		def := fmt.Sprintf("    %s %s", c.name, d.Type(c.sqlType))
+		if c.constraint != "" {
+			def += " " + c.constraint
+		}
+		defs = append(defs, def)
+	}
+	for _, c := range constraints {
+		defs = append(defs, "    "+c)
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n);\n", table, strings.Join(defs, ",\n"))
+}
+
 func (g *Generator) Close() error {
-	if g.started {
-		if _, err := g.Writer.WriteString("\\.\n"); err != nil {
+	if g.Relational {
+		if err := g.writeTables(); err != nil {
+			return err
+		}
+	}
+	if g.rows != nil {
+		if err := g.closeRows(g.rows); err != nil {
+			return err
+		}
+	}
+	if g.Append {
+		if _, err := g.Writer.WriteString("\n" + g.Dialect.Merge("nodes", nodesStaging, columnNames(g.nodesColumns()), nodesKey) +
+			g.Dialect.DropTable(nodesStaging)); err != nil {
 			return err
 		}
 	}
+	if err := g.closeClosure(); err != nil {
+		return err
+	}
+	if err := g.writeIngests(); err != nil {
+		return err
+	}
 	if err := g.Writer.Flush(); err != nil {
 		return err
 	}
+	if g.File == nil {
+		return nil
+	}
 	return g.File.Close()
 }
 
 func (g *Generator) WriteInitScript() error {
-	schema := `DROP TABLE IF EXISTS nodes CASCADE;
-
-CREATE TABLE nodes (
-    id VARCHAR,
-    parent_id VARCHAR, 
-    name VARCHAR NOT NULL,
-    path VARCHAR NOT NULL,
-    data_type VARCHAR NOT NULL,
-    value TEXT
-);
-`
+	ingests := createTable(g.Dialect, "ingests", ingestsColumns)
+	constraints, indexes := g.nodesConstraints()
+	nodes := createTable(g.Dialect, "nodes", g.nodesColumns(), constraints...) + indexes
+	if g.ltree() {
+		nodes = "CREATE EXTENSION IF NOT EXISTS ltree;\n" + nodes
+	}
+
+	var schema string
+	switch {
+	case g.Relational && (g.Append || g.Keys || g.TreeViews || g.TreePath):
+		return errors.New("append mode, keys, tree views and tree paths only support the nodes table, not Relational")
+	case g.Append:
+		schema = ifNotExists(nodes) + "\n" +
+			ifNotExists(ingests) + "\n" +
+			g.Dialect.DropTable(nodesStaging) + "\n" + createTable(g.Dialect, nodesStaging, g.nodesColumns())
+		if g.Closure {
+			schema += "\n" + ifNotExists(g.closureTable()) + "\n" +
+				g.Dialect.DropTable(closureStaging) + "\n" + createTable(g.Dialect, closureStaging, closureColumns)
+		}
+	case g.Relational:
+		schema = g.Dialect.DropTable("ingests") + "\n" + ingests
+		if g.Closure {
+			schema += "\n" + g.Dialect.DropTable("node_closure") + "\n" + g.closureTable()
+		}
+		_, err := g.Writer.WriteString(schema)
+		return err
+	default:
+		schema = g.Dialect.DropTable("nodes") + "\n" + nodes + "\n" +
+			g.Dialect.DropTable("ingests") + "\n" + ingests
+		if g.Closure {
+			schema += "\n" + g.Dialect.DropTable("node_closure") + "\n" + g.closureTable()
+		}
+	}
+
+	var views []string
+	if g.TreeViews {
+		tree, err := g.treeViews()
+		if err != nil {
+			return err
+		}
+		views = append(views, treeViews...)
+		schema += "\n" + tree
+	}
+	if g.TypedViews {
+		views = append(views, "nodes_typed")
+		schema += "\n" + g.typedView()
+	}
+	for i := len(views) - 1; i >= 0; i-- {
+		schema = fmt.Sprintf("DROP VIEW IF EXISTS %s;\n", views[i]) + schema
+	}
+
 	_, err := g.Writer.WriteString(schema)
 	return err
 }
 
+func ifNotExists(create string) string {
+	return strings.Replace(create, "CREATE TABLE ", "CREATE TABLE IF NOT EXISTS ", 1)
+}
+
+// typedView selects each nodes row with an extra value_<type> column for
+// every SQL type other than TEXT, set when the row's type maps to it.
+func (g *Generator) typedView() string {
+	byType := make(map[string][]string)
+	for dataType, sqlType := range g.types {
+		if sqlType != "TEXT" {
+			byType[sqlType] = append(byType[sqlType], fmt.Sprintf("'%s'", dataType))
+		}
+	}
+
+	var sqlTypes []string
+	for sqlType := range byType {
+		sqlTypes = append(sqlTypes, sqlType)
+	}
+	sort.Strings(sqlTypes)
+
+	view := "CREATE VIEW nodes_typed AS\nSELECT n.*"
+	for _, sqlType := range sqlTypes {
+		dataTypes := byType[sqlType]
+		sort.Strings(dataTypes)
+
+		view += fmt.Sprintf(",\n    CASE WHEN COALESCE(n.semantic_type, n.data_type) IN (%s) THEN %s END AS value_%s",
+			strings.Join(dataTypes, ", "), g.Dialect.Cast("n.value", sqlType), strings.ToLower(sqlType))
+	}
+	view += "\nFROM nodes n;\n"
+
+	return view
+}
+
 func (g *Generator) WriteLeaves(leaves []json2Leaf.Leaf) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if !g.started {
-		if _, err := g.Writer.WriteString("COPY nodes (id, parent_id, name, path, data_type, value) FROM stdin;\n"); err != nil {
+	for _, leaf := range leaves {
+		if err := g.writeLeaf(leaf); err != nil {
 			return err
 		}
-		g.started = true
 	}
 
-	for _, leaf := range leaves {
-		value := leaf.Value
-		if leaf.Name == "_tree" {
-			value = cleanName(fmt.Sprintf("%v", value))
-		}
-		value = formatValue(value, leaf.DataType)
-
-		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\n",
-			escapeCopy(leaf.ID),
-			nullableStringCopy(leaf.ParentID),
-			escapeCopy(cleanName(leaf.Name)),
-			escapeCopy(leaf.Path),
-			escapeCopy(leaf.DataType),
-			value,
-		)
-		if _, err := g.Writer.WriteString(row); err != nil {
+	return g.Writer.Flush()
+}
+
+// WriteLeaf writes a single leaf. Output is flushed as the buffer fills and
+// on Close.
+func (g *Generator) WriteLeaf(leaf json2Leaf.Leaf) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.writeLeaf(leaf)
+}
+
+func (g *Generator) writeLeaf(leaf json2Leaf.Leaf) error {
+	in := g.ingest()
+	in.Leaves++
+
+	if g.Closure {
+		if g.closed == nil {
+			g.closed = make(map[string]bool)
+			g.parents = make(map[string]string)
+		}
+		if err := g.writeClosure(leaf); err != nil {
 			return err
 		}
 	}
 
-	return g.Writer.Flush()
+	if g.Relational {
+		if g.tables == nil {
+			g.tables = NewTableSet()
+			g.rowIngests = make(map[string]*Ingest)
+		}
+		g.tables.Add(leaf)
+		g.rowIngests[leaf.ID] = in
+
+		return nil
+	}
+
+	if g.rows == nil {
+		table := "nodes"
+		if g.Append {
+			table = nodesStaging
+		}
+
+		rows, err := g.newRows(g.Writer, table, table, g.nodesColumns())
+		if err != nil {
+			return err
+		}
+		g.rows = rows
+	}
+
+	row := append(nodeRow(leaf), in.values(leafHash(leaf))...)
+	if g.TreePath {
+		row = append(row, treePath(leaf))
+	}
+
+	return g.rows.WriteRow(row)
 }
 
-func formatValue(v interface{}, dataType string) string {
+// nodeRow converts a leaf to the values of a nodes row.
+func nodeRow(leaf json2Leaf.Leaf) []interface{} {
+	value := leaf.Value
+	if leaf.Name == "_tree" {
+		value = cleanName(fmt.Sprintf("%v", value))
+	}
+
+	return []interface{}{
+		leaf.ID,
+		nullable(leaf.ParentID),
+		cleanName(leaf.Name),
+		leaf.Path,
+		leaf.DataType,
+		textValue(value, leaf.DataType),
+		ordValue(leaf.Index, leaf.HasIndex),
+		nullable(leaf.Location),
+		nullable(leaf.SemanticType),
+	}
+}
+
+func (g *Generator) sqlType(dataType string) string {
+	if t, ok := g.types[dataType]; ok {
+		return t
+	}
+
+	return "TEXT"
+}
+
+// writeTables writes the DDL and rows of every table. Parents come before
+// their children, so the foreign keys hold as soon as a table is loaded.
+func (g *Generator) writeTables() error {
+	if g.tables == nil {
+		return nil
+	}
+	tables := parentsFirst(g.tables.Tables())
+
+	for i := len(tables) - 1; i >= 0; i-- {
+		if _, err := g.Writer.WriteString(g.Dialect.DropTable(g.Dialect.Quote(tables[i].Name))); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tables {
+		var constraints []string
+		if t.Parent != "" {
+			constraints = append(constraints, fmt.Sprintf("FOREIGN KEY (_parent_id) REFERENCES %s (_id)", g.Dialect.Quote(t.Parent)))
+		}
+
+		if _, err := g.Writer.WriteString("\n" + createTable(g.Dialect, g.Dialect.Quote(t.Name), g.tableColumns(t), constraints...)); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tables {
+		columns := g.tableColumns(t)
+
+		if _, err := g.Writer.WriteString("\n"); err != nil {
+			return err
+		}
+
+		rows, err := g.newRows(g.Writer, t.Name, g.Dialect.Quote(t.Name), columns)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range t.Rows {
+			values := []interface{}{r.ID, nullable(r.ParentID), ordValue(r.Index, r.HasIndex)}
+			for i, c := range t.Columns {
+				values = append(values, cellValue(r.Values[c.Path], columns[i+3].sqlType))
+			}
+			values = append(values, g.rowIngests[r.ID].values(r.Hash)...)
+
+			if err := rows.WriteRow(values); err != nil {
+				return err
+			}
+		}
+
+		if err := g.closeRows(rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newRows starts the rows of a table, named name before quoting, written to w.
+func (g *Generator) newRows(w io.Writer, name, table string, columns []columnDef) (RowWriter, error) {
+	if g.load != nil {
+		return g.load(table, columnNames(columns))
+	}
+	if g.BinaryDir == "" {
+		return g.Dialect.Rows(w, table, columnNames(columns))
+	}
+
+	if _, ok := g.Dialect.(PostgreSQL); !ok {
+		return nil, fmt.Errorf("binary COPY isn't supported by %s", g.Dialect.Name())
+	}
+
+	return newBinaryRows(w, g.BinaryDir, name, table, columns)
+}
+
+// closeRows closes a block of rows, keeping count of the NULs its dialect
+// had to drop.
+func (g *Generator) closeRows(rows RowWriter) error {
+	if r, ok := rows.(interface{ DroppedNULs() int }); ok {
+		g.nuls += r.DroppedNULs()
+	}
+
+	return rows.Close()
+}
+
+// DroppedNULs is the number of NUL bytes removed from values that the
+// dialect can't load, known once the Generator is closed.
+func (g *Generator) DroppedNULs() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.nuls
+}
+
+func (g *Generator) tableColumns(t *Table) []columnDef {
+	columns := []columnDef{
+		{"_id", "VARCHAR", "PRIMARY KEY"},
+		{"_parent_id", "VARCHAR", ""},
+		{"_ord", "INTEGER", ""},
+	}
+	for _, c := range t.Columns {
+		columns = append(columns, columnDef{g.Dialect.Quote(c.Name), g.columnType(c), ""})
+	}
+	for _, c := range ingestColumns {
+		columns = append(columns, columnDef{"_" + c.name, c.sqlType, c.constraint})
+	}
+
+	return columns
+}
+
+// columnType is the SQL type of a table column. DuckDB has no unbounded
+// NUMERIC, so its numbers get a DECIMAL of the digits they need, or are text
+// when they need more than it holds.
+func (g *Generator) columnType(c Column) string {
+	sqlType := g.sqlType(c.DataType)
+	if _, ok := g.Dialect.(DuckDB); !ok || sqlType != "NUMERIC" {
+		return sqlType
+	}
+	if c.Precision > maxDecimalPrecision {
+		return "TEXT"
+	}
+
+	return fmt.Sprintf("DECIMAL(%d,%d)", c.Precision, c.Scale)
+}
+
+// parentsFirst orders tables so each comes after its parent, dropping the
+// parent of any table that is part of a cycle.
+func parentsFirst(tables []*Table) (r []*Table) {
+	byName := make(map[string]*Table)
+	for _, t := range tables {
+		byName[t.Name] = t
+	}
+
+	done := make(map[string]bool)
+	visiting := make(map[string]bool)
+
+	var visit func(t *Table)
+	visit = func(t *Table) {
+		if done[t.Name] {
+			return
+		}
+		visiting[t.Name] = true
+
+		if p, ok := byName[t.Parent]; ok {
+			if visiting[p.Name] {
+				t.Parent = ""
+			} else {
+				visit(p)
+			}
+		} else {
+			t.Parent = ""
+		}
+
+		visiting[t.Name] = false
+		done[t.Name] = true
+		r = append(r, t)
+	}
+
+	for _, t := range tables {
+		visit(t)
+	}
+
+	return
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func ordValue(index int, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return index
+}
+
+// textValue is the text of a leaf value as written to TEXT columns, or nil
+// for NULL.
+func textValue(v interface{}, dataType string) interface{} {
 	if v == nil {
-		return "\\N"
+		return nil
 	}
 
 	switch dataType {
 	case "string":
-		return escapeCopy(fmt.Sprintf("%v", v))
+		return fmt.Sprintf("%v", v)
 	case "bool":
 		return strings.ToLower(fmt.Sprintf("%v", v))
 	case "float64":
+		// %v switches to exponent notation for large values
+		if f, ok := v.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return fmt.Sprintf("%v", v)
+	case "integer", "decimal":
 		return fmt.Sprintf("%v", v)
 	default:
 		jsonBytes, err := json.Marshal(v)
 		if err != nil {
-			return "\\N"
+			return nil
 		}
-		return escapeCopy(string(jsonBytes))
+		return string(jsonBytes)
 	}
 }
 
-func escapeCopy(s string) string {
-	s = strings.ReplaceAll(s, "\x00", "")
-	s = strings.ReplaceAll(s, "\t", "\\t")
-	s = strings.ReplaceAll(s, "\n", "\\n")
-	s = strings.ReplaceAll(s, "\r", "\\r")
-	s = strings.ReplaceAll(s, "\\", "\\\\")
-	return s
-}
+// cellValue converts a value for a column of sqlType, keeping numbers and
+// booleans typed unless the column holds text.
+func cellValue(v Value, sqlType string) interface{} {
+	if v.Value == nil {
+		return nil
+	}
 
-func nullableStringCopy(s string) string {
-	if s == "" {
-		return "\\N"
+	switch sqlType {
+	case "TEXT", "VARCHAR":
+		return textValue(v.Value, v.DataType)
+	}
+
+	switch t := v.Value.(type) {
+	case float64:
+		return json.Number(strconv.FormatFloat(t, 'f', -1, 64))
+	case string:
+		if sqlType == "BOOLEAN" {
+			return strings.EqualFold(t, "true")
+		}
+		return t
+	case bool, json.Number:
+		return t
 	}
-	return escapeCopy(s)
+
+	return textValue(v.Value, v.DataType)
 }
@@ -0,0 +1,249 @@
+package schema_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/jbrough/json2Leaf/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapDocs maps each document, named doc, to a batch of leaves.
+func mapDocs(t *testing.T, c j.Config, docs ...string) (r [][]j.Leaf) {
+	for _, doc := range docs {
+		ls, err := j.NewMapper(c).Do("doc", []byte(doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = append(r, ls)
+	}
+
+	return
+}
+
+// leafWriter is any of the package's writers.
+type leafWriter interface {
+	WriteLeaves(ls []j.Leaf) error
+	Close() error
+}
+
+// writeLeaves maps each document, writes its leaves to w and closes it.
+func writeLeaves(t *testing.T, w leafWriter, c j.Config, docs ...string) {
+	for _, ls := range mapDocs(t, c, docs...) {
+		if err := w.WriteLeaves(ls); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// generate maps each document and returns what the generator wrote.
+func generate(t *testing.T, g *schema.Generator, c j.Config, docs ...string) string {
+	return generateLeaves(t, g, mapDocs(t, c, docs...)...)
+}
+
+// generateLeaves writes each batch of leaves and returns what the generator
+// wrote.
+func generateLeaves(t *testing.T, g *schema.Generator, batches ...[]j.Leaf) string {
+	path := filepath.Join(t.TempDir(), "output.sql")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.File = f
+	g.Writer = bufio.NewWriter(f)
+
+	if err := g.WriteInitScript(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ls := range batches {
+		if err := g.WriteLeaves(ls); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestRelational(t *testing.T) {
+	g := schema.NewGenerator()
+	g.Relational = true
+
+	c := j.Config{DeterministicIDs: true}
+
+	in := g.BeginIngest("reports/doc.json")
+	out := generate(t, g, c,
+		`{"order": "A1", "lines": [{"sku": "x", "qty": 1}, {"sku": "y", "qty": 2.5}]}`,
+	)
+
+	ls, err := j.NewMapper(c).Do("doc", []byte(`{"order": "A1", "lines": [{"sku": "x", "qty": 1}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]string)
+	for _, l := range ls {
+		ids[l.Name] = l.ID
+	}
+
+	assert.Contains(t, out, `CREATE TABLE "doc" (
+    _id VARCHAR PRIMARY KEY,
+    _parent_id VARCHAR,
+    _ord INTEGER,
+    "order" TEXT,
+    _ingest_id VARCHAR,
+    _source TEXT,
+    _content_hash VARCHAR
+);`)
+	assert.Contains(t, out, `CREATE TABLE "doc__lines" (
+    _id VARCHAR PRIMARY KEY,
+    _parent_id VARCHAR,
+    _ord INTEGER,
+    "qty" NUMERIC,
+    "sku" TEXT,
+    _ingest_id VARCHAR,
+    _source TEXT,
+    _content_hash VARCHAR,
+    FOREIGN KEY (_parent_id) REFERENCES "doc" (_id)
+);`)
+	ingest := "\t" + in.ID + "\treports/doc.json\t\\N\n"
+	assert.Contains(t, out, "COPY \"doc\" (_id, _parent_id, _ord, \"order\", _ingest_id, _source, _content_hash) FROM stdin;\n"+ids["doc"]+"\t\\N\t\\N\tA1"+ingest+"\\.\n")
+	assert.Contains(t, out, "COPY \"doc__lines\" (_id, _parent_id, _ord, \"qty\", \"sku\", _ingest_id, _source, _content_hash) FROM stdin;\n"+ids["doc__lines"]+"\t"+ids["doc"]+"\t0\t1\tx"+ingest)
+	assert.Contains(t, out, "\t1\t2.5\ty"+ingest+"\\.\n")
+	assert.NotContains(t, out, "nodes")
+}
+
+func TestCopyEscaping(t *testing.T) {
+	g := schema.NewGenerator()
+
+	out := generate(t, g, j.Config{}, `{"a": "tab\there", "b": "back\\tslash", "c": "nul\u0000"}`)
+
+	assert.Contains(t, out, "\ttab\\there\t")
+	assert.Contains(t, out, "\tback\\\\tslash\t")
+	assert.Contains(t, out, "\tnul\t")
+	assert.Equal(t, 1, g.DroppedNULs())
+}
+
+func TestOrd(t *testing.T) {
+	// a Leaf built by hand isn't an array element unless it says so
+	out := generateLeaves(t, schema.NewGenerator(), []j.Leaf{
+		{DataType: "string", Name: "doc", ID: "a", Path: "val", Value: "x"},
+		{DataType: "string", Name: "doc", ID: "b", Path: "val", Value: "y", HasIndex: true},
+	})
+
+	assert.Contains(t, out, "a\t\\N\tdoc\tval\tstring\tx\t\\N\t")
+	assert.Contains(t, out, "b\t\\N\tdoc\tval\tstring\ty\t0\t")
+}
+
+func TestIngests(t *testing.T) {
+	g := schema.NewGenerator()
+
+	a := g.BeginIngest("in/a__0123456789abcdef0123456789abcdef.json")
+	b := g.BeginIngest("in/b.json")
+	assert.Less(t, a.ID, b.ID)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", a.Hash)
+	assert.Empty(t, b.Hash)
+
+	out := generate(t, g, j.Config{}, `{"a": 1}`)
+
+	assert.Contains(t, out, "CREATE TABLE ingests (\n    ingest_id VARCHAR PRIMARY KEY,")
+	assert.Contains(t, out, "COPY nodes (id, parent_id, name, path, data_type, value, ord, location, semantic_type, ingest_id, source, content_hash) FROM stdin;\n")
+	assert.Contains(t, out, "\t"+b.ID+"\tin/b.json\t\\N\n")
+	assert.Contains(t, out, "COPY ingests (ingest_id, source, content_hash, started_at, leaves) FROM stdin;\n"+a.ID+"\tin/a__0123456789abcdef0123456789abcdef.json\t0123456789abcdef0123456789abcdef\t")
+	assert.Contains(t, out, "\t0\n"+b.ID+"\tin/b.json\t\\N\t")
+
+	ingests := g.Ingests()
+	if assert.Len(t, ingests, 2) {
+		assert.Equal(t, 0, ingests[0].Leaves)
+		assert.Equal(t, 2, ingests[1].Leaves)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	g := schema.NewGenerator()
+
+	ls, err := j.NewMapper(j.Config{}).Do("doc__0123456789abcdef0123456789abcdef", []byte(`{"a": [1]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := generateLeaves(t, g, ls)
+	in := g.Ingests()[0]
+
+	assert.NotContains(t, out, "\tdoc__0123456789abcdef0123456789abcdef")
+	assert.Contains(t, out, "\tdoc__a\tval\tfloat64\t1\t0\t$['a'][0]\t\\N\t"+in.ID+"\t\\N\t0123456789abcdef0123456789abcdef\n")
+}
+
+func TestClosure(t *testing.T) {
+	// rows are spooled to a temporary file until Close
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	ls, err := j.NewMapper(j.Config{}).Do("doc", []byte(`{"x": 1, "a": [{"y": 2, "b": [{"c": 3}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closure := func(ls []j.Leaf) []string {
+		g := schema.NewGenerator()
+		g.Relational = true
+		g.Closure = true
+
+		out := generateLeaves(t, g, ls)
+		assert.Contains(t, out, "DROP TABLE IF EXISTS node_closure CASCADE;\n\nCREATE TABLE node_closure (")
+
+		header := "COPY node_closure (ancestor_id, descendant_id, depth) FROM stdin;\n"
+		start := strings.Index(out, header)
+		if !assert.GreaterOrEqual(t, start, 0) {
+			return nil
+		}
+		block := out[start+len(header):]
+
+		rows := strings.Split(strings.TrimSuffix(block[:strings.Index(block, "\\.\n")], "\n"), "\n")
+		sort.Strings(rows)
+		return rows
+	}
+
+	ids := make(map[string]j.Leaf)
+	for _, l := range ls {
+		ids[l.Name] = l
+	}
+	c := ids["doc__a__b"]
+	rows := closure(ls)
+	assert.Len(t, rows, 6)
+	assert.Contains(t, rows, c.ID+"\t"+c.ID+"\t0")
+	assert.Contains(t, rows, ids["doc__a"].ID+"\t"+c.ID+"\t1")
+	assert.Contains(t, rows, ids["doc"].ID+"\t"+c.ID+"\t2")
+
+	// without lineage, ancestors are found by following parent ids
+	var parents []j.Leaf
+	for _, l := range ls {
+		l.Lineage = nil
+		parents = append(parents, l)
+	}
+	assert.Equal(t, closure(ls), closure(parents))
+
+	spooled, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, spooled)
+}
@@ -0,0 +1,116 @@
+package schema
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// Ingest is a load of one source file. Every row carries the ID of the ingest
+// it was written in, so a load can be compared with others or deleted again.
+type Ingest struct {
+	// ID is a ULID, so ingests sort by the time they started.
+	ID     string
+	Source string
+	// Hash is the content hash in the source's file name, if any.
+	Hash    string
+	Started time.Time
+	Leaves  int
+}
+
+// ingestColumns are added to every row, after the columns of its table.
+var ingestColumns = []columnDef{
+	{"ingest_id", "VARCHAR", ""},
+	{"source", "TEXT", ""},
+	{"content_hash", "VARCHAR", ""},
+}
+
+var ingestsColumns = []columnDef{
+	{"ingest_id", "VARCHAR", "PRIMARY KEY"},
+	{"source", "TEXT", ""},
+	{"content_hash", "VARCHAR", ""},
+	{"started_at", "TIMESTAMPTZ", "NOT NULL"},
+	{"leaves", "BIGINT", "NOT NULL"},
+}
+
+// BeginIngest starts an ingest of source, which rows written from then on
+// belong to. Rows written before any call belong to an ingest without a
+// source.
+func (g *Generator) BeginIngest(source string) *Ingest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.beginIngest(source)
+}
+
+func (g *Generator) beginIngest(source string) *Ingest {
+	name := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	in := &Ingest{
+		ID:      ulid.Make().String(),
+		Source:  source,
+		Hash:    contentHash(name),
+		Started: time.Now().UTC(),
+	}
+	g.ingests = append(g.ingests, in)
+
+	return in
+}
+
+// ingest is the current ingest, started if there isn't one.
+func (g *Generator) ingest() *Ingest {
+	if len(g.ingests) == 0 {
+		return g.beginIngest("")
+	}
+
+	return g.ingests[len(g.ingests)-1]
+}
+
+// Ingests returns every ingest started so far.
+func (g *Generator) Ingests() []Ingest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r := make([]Ingest, len(g.ingests))
+	for i, in := range g.ingests {
+		r[i] = *in
+	}
+
+	return r
+}
+
+func (in *Ingest) values(hash string) []interface{} {
+	return []interface{}{in.ID, nullable(in.Source), nullable(hash)}
+}
+
+func (g *Generator) writeIngests() error {
+	if len(g.ingests) == 0 {
+		return nil
+	}
+
+	if _, err := g.Writer.WriteString("\n"); err != nil {
+		return err
+	}
+
+	rows, err := g.newRows(g.Writer, "ingests", "ingests", ingestsColumns)
+	if err != nil {
+		return err
+	}
+
+	for _, in := range g.ingests {
+		values := []interface{}{
+			in.ID,
+			nullable(in.Source),
+			nullable(in.Hash),
+			in.Started.Format("2006-01-02 15:04:05.999999-07:00"),
+			in.Leaves,
+		}
+
+		if err := rows.WriteRow(values); err != nil {
+			return err
+		}
+	}
+
+	return g.closeRows(rows)
+}
@@ -0,0 +1,246 @@
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jbrough/json2Leaf"
+)
+
+// Loader writes leaves to a database, or a script loading them into one.
+type Loader interface {
+	// Begin creates the tables leaves are written to.
+	Begin() error
+	WriteLeaves(leaves []json2Leaf.Leaf) error
+	// Close writes anything held back and finishes the load.
+	Close() error
+}
+
+// Begin writes the init script, which makes a Generator the Loader of a
+// script.
+func (g *Generator) Begin() error {
+	return g.WriteInitScript()
+}
+
+// SQLLoader loads leaves straight into a database through database/sql, in
+// a single transaction that is committed on Close, or rolled back if any
+// statement fails. It creates the same tables as its Generator's script and
+// inserts rows in batches of BatchSize, as parameterised multi-row INSERTs.
+//
+// DDL can't be rolled back on MySQL, which commits the transaction before
+// each CREATE or DROP.
+type SQLLoader struct {
+	DB *sql.DB
+	// Generator sets the dialect and options of the tables loaded. Begin
+	// replaces its Writer and sends its rows to the database, so it belongs
+	// to the SQLLoader from then on, and its File isn't used.
+	Generator *Generator
+	BatchSize int
+	tx        *sql.Tx
+	script    bytes.Buffer
+	err       error
+}
+
+func NewSQLLoader(db *sql.DB, g *Generator) *SQLLoader {
+	return &SQLLoader{DB: db, Generator: g, BatchSize: defaultBatchSize}
+}
+
+func (l *SQLLoader) Begin() error {
+	if l.Generator.BinaryDir != "" {
+		return l.fail(errors.New("binary COPY files can't be loaded through database/sql"))
+	}
+
+	tx, err := l.DB.Begin()
+	if err != nil {
+		return l.fail(err)
+	}
+	l.tx = tx
+
+	g := l.Generator
+	g.Writer = bufio.NewWriter(&l.script)
+	g.load = l.rows
+
+	return l.fail(g.WriteInitScript())
+}
+
+func (l *SQLLoader) WriteLeaves(leaves []json2Leaf.Leaf) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	return l.fail(l.Generator.WriteLeaves(leaves))
+}
+
+// WriteLeaf loads a single leaf.
+func (l *SQLLoader) WriteLeaf(leaf json2Leaf.Leaf) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	return l.fail(l.Generator.WriteLeaf(leaf))
+}
+
+func (l *SQLLoader) Close() error {
+	// nothing was begun, so there's nothing to finish
+	if l.tx == nil {
+		if l.err == nil {
+			l.err = errors.New("SQLLoader closed before Begin")
+		}
+		return l.err
+	}
+
+	if l.err == nil {
+		l.fail(l.Generator.Close())
+	}
+	if l.err == nil {
+		l.fail(l.exec())
+	}
+
+	if l.err != nil {
+		l.tx.Rollback()
+		return l.err
+	}
+
+	return l.tx.Commit()
+}
+
+// fail keeps the first error, after which the load can only be rolled back.
+func (l *SQLLoader) fail(err error) error {
+	if err != nil && l.err == nil {
+		l.err = err
+	}
+
+	return err
+}
+
+// exec runs the statements the Generator has written since the last call.
+// Statements are split at semicolons that end a line, which is how the
+// Generator ends them, and none of its statements have one inside.
+func (l *SQLLoader) exec() error {
+	if err := l.Generator.Writer.Flush(); err != nil {
+		return err
+	}
+	script := l.script.String()
+	l.script.Reset()
+
+	for _, stmt := range strings.SplitAfter(script, ";\n") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := l.tx.Exec(stmt); err != nil {
+			return fmt.Errorf("%w: %s", err, stmt)
+		}
+	}
+
+	return nil
+}
+
+// rows starts the rows of a table, after running the statements written
+// before them, which create it.
+func (l *SQLLoader) rows(table string, columns []string) (RowWriter, error) {
+	if err := l.exec(); err != nil {
+		return nil, err
+	}
+
+	batch := l.BatchSize
+	if batch <= 0 {
+		batch = defaultBatchSize
+	}
+
+	return &sqlRows{l: l, table: table, columns: columns, batch: batch}, nil
+}
+
+// sqlRows inserts rows in batches, reusing a prepared statement for each full
+// batch.
+type sqlRows struct {
+	l       *SQLLoader
+	table   string
+	columns []string
+	batch   int
+	values  []interface{}
+	n       int
+	stmt    *sql.Stmt
+	nuls    int
+}
+
+func (r *sqlRows) WriteRow(values []interface{}) error {
+	if len(values) != len(r.columns) {
+		return fmt.Errorf("got %d values for %d columns", len(values), len(r.columns))
+	}
+
+	for _, v := range values {
+		// no database takes NULs in text
+		if s, ok := v.(string); ok && strings.Contains(s, "\x00") {
+			r.nuls += strings.Count(s, "\x00")
+			v = strings.ReplaceAll(s, "\x00", "")
+		}
+		r.values = append(r.values, v)
+	}
+	r.n++
+
+	if r.n == r.batch {
+		return r.flush()
+	}
+
+	return nil
+}
+
+func (r *sqlRows) flush() error {
+	if r.n == 0 {
+		return nil
+	}
+
+	var err error
+	if r.n == r.batch {
+		if r.stmt == nil {
+			r.stmt, err = r.l.tx.Prepare(r.insert(r.n))
+			if err != nil {
+				return err
+			}
+		}
+		_, err = r.stmt.Exec(r.values...)
+	} else {
+		_, err = r.l.tx.Exec(r.insert(r.n), r.values...)
+	}
+
+	r.values, r.n = r.values[:0], 0
+	return err
+}
+
+// insert is an INSERT of n rows of parameters.
+func (r *sqlRows) insert(n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "INSERT INTO %s (%s) VALUES ", r.table, strings.Join(r.columns, ", "))
+
+	p := 1
+	params := make([]string, len(r.columns))
+	for i := 0; i < n; i++ {
+		for j := range params {
+			params[j] = r.l.Generator.Dialect.Placeholder(p)
+			p++
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(" + strings.Join(params, ", ") + ")")
+	}
+
+	return b.String()
+}
+
+func (r *sqlRows) Close() error {
+	err := r.flush()
+	if r.stmt != nil {
+		r.stmt.Close()
+	}
+
+	return err
+}
+
+func (r *sqlRows) DroppedNULs() int {
+	return r.nuls
+}
@@ -0,0 +1,108 @@
+package schema_test
+
+import (
+	"database/sql"
+	"testing"
+
+	j "github.com/jbrough/json2Leaf"
+	"github.com/jbrough/json2Leaf/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func load(t *testing.T, db *sql.DB, g *schema.Generator, batch int, docs ...string) error {
+	l := schema.NewSQLLoader(db, g)
+	l.BatchSize = batch
+
+	if err := l.Begin(); err != nil {
+		return err
+	}
+
+	for _, ls := range mapDocs(t, j.Config{}, docs...) {
+		if err := l.WriteLeaves(ls); err != nil {
+			l.Close()
+			return err
+		}
+	}
+
+	return l.Close()
+}
+
+func TestSQLLoader(t *testing.T) {
+	db := openSQLite(t, "")
+
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.TypedViews = true
+	g.Closure = true
+	g.Keys = true
+	if err := load(t, db, g, 4, dialectDoc, `{"a": "x\u0000y"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	sg := schema.NewGenerator()
+	sg.Dialect = schema.SQLite{}
+	script := openSQLite(t, generate(t, sg, j.Config{}, dialectDoc, `{"a": 1}`))
+	leaves := count(t, script, `SELECT COUNT(*) FROM nodes`)
+
+	assert.Equal(t, leaves, count(t, db, `SELECT COUNT(*) FROM nodes`))
+	assert.Equal(t, 1, count(t, db, `SELECT COUNT(*) FROM nodes WHERE value = 'xy'`))
+	assert.Equal(t, 1, g.DroppedNULs())
+	assert.Equal(t, 3, count(t, db, `SELECT COUNT(*) FROM nodes_typed WHERE value_numeric IS NOT NULL`))
+	assert.Equal(t, 3, count(t, db, `SELECT COUNT(*) FROM node_closure c JOIN nodes n ON n.id = c.descendant_id WHERE c.depth = 1 AND n.path = 'sku'`))
+	assert.Equal(t, 1, count(t, db, `SELECT COUNT(*) FROM ingests`))
+}
+
+func TestSQLLoaderRelational(t *testing.T) {
+	db := openSQLite(t, "")
+
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Relational = true
+	if err := load(t, db, g, 2, dialectDoc); err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM "doc__items" i JOIN "doc" d ON d._id = i._parent_id`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, n)
+}
+
+func TestSQLLoaderRollback(t *testing.T) {
+	db := openSQLite(t, "")
+
+	g := schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	if err := load(t, db, g, 0, `{"a": 1}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes has no unique key to merge on, so the load fails at the end
+	g = schema.NewGenerator()
+	g.Dialect = schema.SQLite{}
+	g.Append = true
+	assert.Error(t, load(t, db, g, 0, `{"a": 2}`))
+
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'nodes_staging'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, n)
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ingests`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, n)
+
+	// a load that couldn't begin keeps failing, and closes without a
+	// transaction
+	g = schema.NewGenerator()
+	g.BinaryDir = t.TempDir()
+	l := schema.NewSQLLoader(db, g)
+	err := l.Begin()
+	assert.Error(t, err)
+	assert.Equal(t, err, l.WriteLeaves(nil))
+	assert.Equal(t, err, l.Close())
+
+	assert.Error(t, schema.NewSQLLoader(db, schema.NewGenerator()).Close())
+}
@@ -0,0 +1,246 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/jbrough/json2Leaf"
+)
+
+// Table is a node name with one row per node and one column per path, as
+// produced by TableSet.
+type Table struct {
+	Name string
+	// Parent is the table that every row's parent node belongs to, or empty
+	// for top level tables and tables whose parents are in more than one.
+	Parent  string
+	Columns []Column
+	Rows    []*Row
+}
+
+type Column struct {
+	Path string
+	// Name is the column's name, which is its Path unless that is taken by
+	// one of the columns every table has, or another path differing only in
+	// case.
+	Name string
+	// DataType unifies the types of the column's values, see
+	// json2Leaf.UnifyTypes.
+	DataType string
+	// Precision and Scale are the digits of a decimal able to hold every
+	// value of a numeric column, in all and after the point.
+	Precision int
+	Scale     int
+}
+
+type Row struct {
+	ID       string
+	ParentID string
+	Index    int
+	HasIndex bool
+	// Hash is the content hash removed from the node name, if any.
+	Hash   string
+	Values map[string]Value
+}
+
+type Value struct {
+	DataType string
+	Value    interface{}
+}
+
+// TableSet groups leaves into a Table per cleaned node name, in the same way
+// as the graph's nodes. Tables can only be typed once every leaf has been
+// seen, so all rows are held in memory.
+type TableSet struct {
+	tables map[string]*tableBuilder
+	// names of node ids, for resolving parent tables
+	names map[string]string
+}
+
+type tableBuilder struct {
+	table Table
+	rows  map[string]*Row
+	types map[string]map[string]int
+}
+
+func NewTableSet() *TableSet {
+	return &TableSet{
+		tables: make(map[string]*tableBuilder),
+		names:  make(map[string]string),
+	}
+}
+
+func (s *TableSet) Add(leaf json2Leaf.Leaf) {
+	if leaf.Name == "_tree" {
+		return
+	}
+
+	name := cleanName(leaf.Name)
+	s.names[leaf.ID] = name
+
+	t, ok := s.tables[name]
+	if !ok {
+		t = &tableBuilder{
+			table: Table{Name: name},
+			rows:  make(map[string]*Row),
+			types: make(map[string]map[string]int),
+		}
+		s.tables[name] = t
+	}
+
+	r, ok := t.rows[leaf.ID]
+	if !ok {
+		r = &Row{
+			ID:       leaf.ID,
+			ParentID: leaf.ParentID,
+			Index:    leaf.Index,
+			HasIndex: leaf.HasIndex,
+			Hash:     contentHash(leaf.Name),
+			Values:   make(map[string]Value),
+		}
+		t.rows[leaf.ID] = r
+		t.table.Rows = append(t.table.Rows, r)
+	}
+
+	r.Values[leaf.Path] = Value{DataType: leaf.DataType, Value: leaf.Value}
+
+	if _, ok := t.types[leaf.Path]; !ok {
+		t.types[leaf.Path] = make(map[string]int)
+	}
+
+	dataType := leaf.DataType
+	if leaf.SemanticType != "" {
+		dataType = leaf.SemanticType
+	}
+	if leaf.Value != nil {
+		t.types[leaf.Path][dataType]++
+	}
+}
+
+// Tables returns the tables sorted by name, with their columns sorted by
+// path.
+func (s *TableSet) Tables() (r []*Table) {
+	var names []string
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := s.tables[name]
+
+		t.table.Columns = nil
+		for path, types := range t.types {
+			c := Column{
+				Path:     path,
+				DataType: json2Leaf.UnifyTypes(types),
+			}
+			if numericTypes[c.DataType] {
+				c.Precision, c.Scale = t.digits(path)
+			}
+			t.table.Columns = append(t.table.Columns, c)
+		}
+		sort.Slice(t.table.Columns, func(i, j int) bool {
+			return t.table.Columns[i].Path < t.table.Columns[j].Path
+		})
+		nameColumns(t.table.Columns)
+
+		parents := make(map[string]bool)
+		for _, row := range t.table.Rows {
+			if row.ParentID != "" {
+				parents[s.names[row.ParentID]] = true
+			}
+		}
+
+		t.table.Parent = ""
+		if len(parents) == 1 {
+			for p := range parents {
+				t.table.Parent = p
+			}
+		}
+
+		r = append(r, &t.table)
+	}
+
+	return
+}
+
+// rowColumns are written to every table before the columns of its paths,
+// and ingest columns after them.
+var rowColumns = []string{"_id", "_parent_id", "_ord", "_ingest_id", "_source", "_content_hash"}
+
+// nameColumns names columns after their paths, numbering any that would clash
+// with rowColumns or each other. Databases differ in whether names are case
+// sensitive, so they're compared ignoring case.
+func nameColumns(columns []Column) {
+	taken := make(map[string]bool)
+	for _, c := range rowColumns {
+		taken[c] = true
+	}
+
+	for i := range columns {
+		name := columns[i].Path
+		for n := 1; taken[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s_%d", columns[i].Path, n)
+		}
+		taken[strings.ToLower(name)] = true
+		columns[i].Name = name
+	}
+}
+
+var numericTypes = map[string]bool{
+	"integer": true,
+	"decimal": true,
+	"float64": true,
+}
+
+// digits is the precision and scale needed by the numbers at path, as
+// written by cellValue.
+func (t *tableBuilder) digits(path string) (precision, scale int) {
+	whole := 1
+	for _, r := range t.table.Rows {
+		n, ok := cellValue(r.Values[path], "NUMERIC").(json.Number)
+		if !ok {
+			continue
+		}
+
+		p, s := numberDigits(n.String())
+		if p-s > whole {
+			whole = p - s
+		}
+		if s > scale {
+			scale = s
+		}
+	}
+
+	return whole + scale, scale
+}
+
+// maxDecimalPrecision is the most digits a Decimal128, or the DECIMAL of
+// most databases, can hold.
+const maxDecimalPrecision = 38
+
+var ten = big.NewInt(10)
+
+// numberDigits counts the digits of a JSON number before and after its
+// point, once written out in full.
+func numberDigits(s string) (precision, scale int) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, 0
+	}
+
+	// the scale is the smallest power of ten the denominator divides
+	pow := big.NewInt(1)
+	for m := new(big.Int); m.Mod(pow, r.Denom()).Sign() != 0; scale++ {
+		pow.Mul(pow, ten)
+	}
+
+	whole := new(big.Int).Quo(r.Num(), r.Denom())
+	precision = len(whole.Abs(whole).String()) + scale
+
+	return
+}
@@ -0,0 +1,177 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jbrough/json2Leaf"
+)
+
+// nodesIndexes are created on nodes with Keys, named nodes_<column>.
+var nodesIndexes = []string{"parent_id", "name", "path"}
+
+// treeViews are the views created with TreeViews, in the order they're
+// dropped.
+var treeViews = []string{"node_ancestors", "node_tree"}
+
+// nodeTreeView has a row per node with its depth and a path of the names of
+// it and its ancestors, like doc/lines[0]/sku. Names are the last part of each
+// node name, as a child's name starts with its parent's unless TableNames
+// gives it another.
+const nodeTreeView = `CREATE VIEW node_tree AS
+WITH RECURSIVE tree (id, parent_id, name, ord, depth, full_path) AS (
+    SELECT id, parent_id, value, ord, 0, value
+    FROM nodes
+    WHERE name = '_tree' AND parent_id IS NULL
+    UNION ALL
+    SELECT n.id, n.parent_id, n.value, n.ord, t.depth + 1,
+        t.full_path || '/' ||
+        CASE WHEN substr(n.value, 1, length(t.name) + 2) = t.name || '__'
+            THEN substr(n.value, length(t.name) + 3)
+            ELSE n.value
+        END ||
+        CASE WHEN n.ord IS NULL THEN '' ELSE '[' || CAST(n.ord AS TEXT) || ']' END
+    FROM nodes n
+    JOIN tree t ON n.parent_id = t.id
+    WHERE n.name = '_tree'
+)
+SELECT * FROM tree;
+`
+
+// nodeAncestorsView has a row for each node and each of its ancestors, which
+// is a subtree query when filtered on ancestor_id.
+const nodeAncestorsView = `CREATE VIEW node_ancestors AS
+WITH RECURSIVE up (id, ancestor_id, distance) AS (
+    SELECT id, parent_id, 1
+    FROM nodes
+    WHERE name = '_tree' AND parent_id IS NOT NULL
+    UNION ALL
+    SELECT u.id, n.parent_id, u.distance + 1
+    FROM up u
+    JOIN nodes n ON n.id = u.ancestor_id AND n.name = '_tree'
+    WHERE n.parent_id IS NOT NULL
+)
+SELECT * FROM up;
+`
+
+// subtreeQuery and ancestorsQuery select the nodes below and above the node
+// with the id in the $1 parameter, starting from the node itself.
+const subtreeQuery = `WITH RECURSIVE down AS (
+        SELECT n.id, n.parent_id, n.value AS name, n.ord, 0 AS depth
+        FROM nodes n
+        WHERE n.name = '_tree' AND n.id = $1
+        UNION ALL
+        SELECT n.id, n.parent_id, n.value, n.ord, d.depth + 1
+        FROM nodes n
+        JOIN down d ON n.parent_id = d.id
+        WHERE n.name = '_tree'
+    )
+    SELECT * FROM down`
+
+const ancestorsQuery = `WITH RECURSIVE up AS (
+        SELECT n.id, n.parent_id, n.value AS name, n.ord, 0 AS distance
+        FROM nodes n
+        WHERE n.name = '_tree' AND n.id = $1
+        UNION ALL
+        SELECT n.id, n.parent_id, n.value, n.ord, u.distance + 1
+        FROM nodes n
+        JOIN up u ON n.id = u.parent_id
+        WHERE n.name = '_tree'
+    )
+    SELECT * FROM up WHERE up.distance > 0`
+
+// treeViews returns the statements creating the tree views, and the subtree
+// and ancestors functions where the dialect has them.
+func (g *Generator) treeViews() (string, error) {
+	var functions string
+
+	switch g.Dialect.(type) {
+	case PostgreSQL:
+		functions = fmt.Sprintf(`
+CREATE OR REPLACE FUNCTION subtree(root VARCHAR)
+RETURNS TABLE (id VARCHAR, parent_id VARCHAR, name TEXT, ord INTEGER, depth INTEGER)
+LANGUAGE sql STABLE AS $$
+    %s
+$$;
+
+CREATE OR REPLACE FUNCTION ancestors(node VARCHAR)
+RETURNS TABLE (id VARCHAR, parent_id VARCHAR, name TEXT, ord INTEGER, distance INTEGER)
+LANGUAGE sql STABLE AS $$
+    %s
+$$;
+`, subtreeQuery, ancestorsQuery)
+
+	case DuckDB:
+		functions = fmt.Sprintf(`
+CREATE OR REPLACE MACRO subtree(root) AS TABLE
+    %s;
+
+CREATE OR REPLACE MACRO ancestors(node) AS TABLE
+    %s;
+`, strings.ReplaceAll(subtreeQuery, "$1", "root"), strings.ReplaceAll(ancestorsQuery, "$1", "node"))
+
+	case MySQL:
+		return "", fmt.Errorf("tree views aren't supported by %s", g.Dialect.Name())
+	}
+
+	return nodeTreeView + "\n" + nodeAncestorsView + functions, nil
+}
+
+// nodesConstraints are the keys and indexes of the nodes table, and the
+// statements creating any indexes that aren't part of the table.
+func (g *Generator) nodesConstraints() (constraints []string, statements string) {
+	switch {
+	case g.Keys:
+		constraints = append(constraints, g.Dialect.PrimaryKey(nodesKey))
+	case g.Append:
+		constraints = append(constraints, g.Dialect.Unique(nodesKey))
+	}
+
+	if g.TreePath {
+		_, postgres := g.Dialect.(PostgreSQL)
+		switch {
+		case g.ltree():
+			statements += "CREATE INDEX IF NOT EXISTS nodes_tree_path ON nodes USING GIST (tree_path);\n"
+		case postgres:
+			// a plain index only serves LIKE 'prefix%' in the C collation
+			statements += createIndex("nodes_tree_path", "nodes", []string{"tree_path text_pattern_ops"})
+		default:
+			constraint, statement := g.Dialect.Index("nodes_tree_path", "nodes", []string{"tree_path"})
+			if constraint != "" {
+				constraints = append(constraints, constraint)
+			}
+			statements += statement
+		}
+	}
+
+	if !g.Keys {
+		return
+	}
+
+	for _, c := range nodesIndexes {
+		constraint, statement := g.Dialect.Index("nodes_"+c, "nodes", []string{c})
+		if constraint != "" {
+			constraints = append(constraints, constraint)
+		}
+		statements += statement
+	}
+
+	return
+}
+
+func (g *Generator) ltree() bool {
+	_, ok := g.Dialect.(PostgreSQL)
+	return g.TreePath && ok && !g.TextTreePath
+}
+
+// treePath joins the ids of the leaf's ancestors and its node with dots, like
+// an ltree. ltree labels can't hold hyphens, so they become underscores.
+func treePath(leaf json2Leaf.Leaf) string {
+	labels := make([]string, 0, len(leaf.Lineage)+1)
+	for _, id := range leaf.Lineage {
+		labels = append(labels, strings.ReplaceAll(id, "-", "_"))
+	}
+	labels = append(labels, strings.ReplaceAll(leaf.ID, "-", "_"))
+
+	return strings.Join(labels, ".")
+}
@@ -0,0 +1,32 @@
+package json2Leaf
+
+// LeafSink receives leaves from a Mapper as they are produced. Mapping waits
+// for WriteLeaf to return, and stops with its error if it fails.
+type LeafSink interface {
+	WriteLeaf(l Leaf) error
+}
+
+// LeafSinkFunc adapts a plain function to a LeafSink.
+type LeafSinkFunc func(l Leaf) error
+
+func (f LeafSinkFunc) WriteLeaf(l Leaf) error {
+	return f(l)
+}
+
+// MapSink returns a LeafSink that passes each leaf through f before writing
+// it to next.
+func MapSink(f func(Leaf) Leaf, next LeafSink) LeafSink {
+	return LeafSinkFunc(func(l Leaf) error {
+		return next.WriteLeaf(f(l))
+	})
+}
+
+// ChanSink sends every leaf on the channel, blocking the Mapper while the
+// channel is full. The caller owns the channel and closes it once mapping has
+// returned.
+type ChanSink chan<- Leaf
+
+func (c ChanSink) WriteLeaf(l Leaf) error {
+	c <- l
+	return nil
+}